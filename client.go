@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librespeed/speedtest/client"
+	"github.com/librespeed/speedtest/web"
+)
+
+// runClient implements the client subcommand, which tests against a LibreSpeed backend
+// from the command line
+func runClient(args []string) {
+	opts := client.DefaultOptions()
+	fs := flag.NewFlagSet("client", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s client -server URL [options]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.Server, "server", "", "base URL of the LibreSpeed Go or PHP backend, e.g. https://speedtest.example.com/")
+	fs.StringVar(&opts.DownloadURL, "dl-url", opts.DownloadURL, "path of the download endpoint")
+	fs.StringVar(&opts.UploadURL, "ul-url", opts.UploadURL, "path of the upload endpoint")
+	fs.StringVar(&opts.PingURL, "ping-url", opts.PingURL, "path of the ping endpoint")
+	fs.StringVar(&opts.GetIPURL, "getip-url", opts.GetIPURL, "path of the IP address endpoint")
+	fs.StringVar(&opts.SessionURL, "session-url", opts.SessionURL, "path of the test session endpoint, empty to run without session")
+	fs.StringVar(&opts.TelemetryURL, "telemetry-url", opts.TelemetryURL, "path of the telemetry endpoint")
+	fs.StringVar(&opts.Tests, "tests", opts.Tests, "tests to run in order: I=IP address, P=ping and jitter, D=download, U=upload")
+	fs.IntVar(&opts.DownloadStreams, "dl-streams", opts.DownloadStreams, "number of parallel download streams")
+	fs.IntVar(&opts.UploadStreams, "ul-streams", opts.UploadStreams, "number of parallel upload streams")
+	fs.DurationVar(&opts.DownloadDuration, "dl-duration", opts.DownloadDuration, "duration of the download test, after the grace time")
+	fs.DurationVar(&opts.UploadDuration, "ul-duration", opts.UploadDuration, "duration of the upload test, after the grace time")
+	fs.DurationVar(&opts.DownloadGraceTime, "dl-grace", opts.DownloadGraceTime, "time at the start of the download test that is not measured")
+	fs.DurationVar(&opts.UploadGraceTime, "ul-grace", opts.UploadGraceTime, "time at the start of the upload test that is not measured")
+	fs.IntVar(&opts.ChunkSize, "chunk-size", opts.ChunkSize, "size of download responses in MiB")
+	fs.IntVar(&opts.UploadSize, "ul-size", opts.UploadSize, "size of upload requests in MiB")
+	fs.IntVar(&opts.Pings, "pings", opts.Pings, "number of pings, the first one is not measured")
+	fs.Float64Var(&opts.OverheadFactor, "overhead", opts.OverheadFactor, "factor applied to the measured speeds to compensate for transport overhead")
+	fs.BoolVar(&opts.Telemetry, "telemetry", false, "report the result to the telemetry endpoint of the backend")
+	fs.StringVar(&opts.TelemetryExtra, "extra", "", "extra data stored with the telemetry")
+	fs.BoolVar(&opts.Insecure, "insecure", false, "don't verify the TLS certificate of the backend")
+	fs.DurationVar(&opts.Timeout, "timeout", opts.Timeout, "timeout of the session, IP address, ping and telemetry requests")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON")
+	verbose := fs.Bool("v", false, "log progress to stderr")
+	_ = fs.Parse(args)
+
+	if opts.Server == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	opts.Tests = strings.ToUpper(opts.Tests)
+	opts.UserAgent = "librespeed-client"
+	if web.Version != "" {
+		opts.UserAgent += "/" + web.Version
+	}
+	log.SetLevel(log.WarnLevel)
+	if *verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	c, err := client.New(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	res, err := c.Run(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *jsonOutput {
+		if err := json.NewEncoder(os.Stdout).Encode(res); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	printResult(res, opts.Tests)
+}
+
+func printResult(res *client.Result, tests string) {
+	fmt.Printf("Server:   %s\n", res.Server)
+	if strings.ContainsRune(tests, 'I') {
+		fmt.Printf("IP:       %s\n", res.IP)
+	}
+	if strings.ContainsRune(tests, 'P') {
+		fmt.Printf("Ping:     %.2f ms\n", res.Ping)
+		fmt.Printf("Jitter:   %.2f ms\n", res.Jitter)
+	}
+	if strings.ContainsRune(tests, 'D') {
+		fmt.Printf("Download: %.2f Mbit/s\n", res.Download)
+	}
+	if strings.ContainsRune(tests, 'U') {
+		fmt.Printf("Upload:   %.2f Mbit/s\n", res.Upload)
+	}
+	if res.ID != "" {
+		fmt.Printf("Test ID:  %s\n", res.ID)
+	}
+}
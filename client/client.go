@@ -0,0 +1,321 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Options configure a test run. Paths are resolved against Server, the defaults are the
+// PHP compatible routes that both the Go and the PHP backends serve.
+type Options struct {
+	Server       string
+	DownloadURL  string
+	UploadURL    string
+	PingURL      string
+	GetIPURL     string
+	SessionURL   string
+	TelemetryURL string
+
+	// Tests lists the tests to run in order: I=IP, P=ping and jitter, D=download, U=upload
+	Tests string
+
+	DownloadStreams  int
+	UploadStreams    int
+	DownloadDuration time.Duration
+	UploadDuration   time.Duration
+	// traffic in the grace time at the start of a test is not measured, while TCP windows grow
+	DownloadGraceTime time.Duration
+	UploadGraceTime   time.Duration
+	// ChunkSize is the ckSize parameter of download requests in MiB
+	ChunkSize int
+	// UploadSize is the size of the body of upload requests in MiB
+	UploadSize int
+	Pings      int
+	// OverheadFactor compensates for the transport overhead, like overheadCompensationFactor of the worker
+	OverheadFactor float64
+
+	Telemetry      bool
+	TelemetryExtra string
+	UserAgent      string
+	Insecure       bool
+	Timeout        time.Duration
+}
+
+// DefaultOptions returns the settings of speedtest_worker.js
+func DefaultOptions() Options {
+	return Options{
+		DownloadURL:       "backend/garbage.php",
+		UploadURL:         "backend/empty.php",
+		PingURL:           "backend/empty.php",
+		GetIPURL:          "backend/getIP.php",
+		SessionURL:        "backend/session",
+		TelemetryURL:      "results/telemetry.php",
+		Tests:             "IPDU",
+		DownloadStreams:   6,
+		UploadStreams:     3,
+		DownloadDuration:  15 * time.Second,
+		UploadDuration:    15 * time.Second,
+		DownloadGraceTime: 1500 * time.Millisecond,
+		UploadGraceTime:   3 * time.Second,
+		ChunkSize:         100,
+		UploadSize:        20,
+		Pings:             10,
+		OverheadFactor:    1.06,
+		UserAgent:         "librespeed-client",
+		Timeout:           10 * time.Second,
+	}
+}
+
+// Result is the outcome of a test run, speeds are in Mbit/s and latencies in milliseconds.
+// Measurements of tests that were not run are zero.
+type Result struct {
+	Server        string    `json:"server"`
+	Timestamp     time.Time `json:"timestamp"`
+	IP            string    `json:"ip,omitempty"`
+	Ping          float64   `json:"ping"`
+	Jitter        float64   `json:"jitter"`
+	Download      float64   `json:"download"`
+	Upload        float64   `json:"upload"`
+	DownloadBytes int64     `json:"download_bytes"`
+	UploadBytes   int64     `json:"upload_bytes"`
+	Session       string    `json:"session,omitempty"`
+	ID            string    `json:"id,omitempty"`
+
+	// ispInfo is the getIP response, reported with the telemetry
+	ispInfo string
+}
+
+// Client runs tests against a LibreSpeed backend
+type Client struct {
+	opts Options
+	base *url.URL
+	http *http.Client
+
+	session string
+}
+
+// New validates the options and creates a client
+func New(opts Options) (*Client, error) {
+	base, err := url.Parse(opts.Server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("invalid server URL %q: scheme must be http or https", opts.Server)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+
+	for _, t := range opts.Tests {
+		if !strings.ContainsRune("IPDU", t) {
+			return nil, fmt.Errorf("unknown test %q, use I, P, D and U", t)
+		}
+	}
+	if opts.DownloadStreams < 1 || opts.UploadStreams < 1 {
+		return nil, errors.New("stream counts must be at least 1")
+	}
+	if opts.ChunkSize < 1 || opts.UploadSize < 1 {
+		return nil, errors.New("chunk and upload sizes must be at least 1 MiB")
+	}
+	// the first ping isn't measured, and jitter needs two more
+	if opts.Pings < 3 {
+		return nil, errors.New("at least 3 pings are needed to measure jitter")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = opts.DownloadStreams + opts.UploadStreams
+	if opts.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &Client{
+		opts: opts,
+		base: base,
+		http: &http.Client{Transport: transport},
+	}, nil
+}
+
+// Run requests a session if the backend supports it, runs the tests and reports the
+// result as telemetry if enabled
+func (c *Client) Run(ctx context.Context) (*Result, error) {
+	res := &Result{Server: c.base.String(), Timestamp: time.Now()}
+
+	c.newSession(ctx)
+	res.Session = c.session
+
+	for _, t := range c.opts.Tests {
+		var err error
+		switch t {
+		case 'I':
+			err = c.getIP(ctx, res)
+		case 'P':
+			res.Ping, res.Jitter, err = c.ping(ctx)
+		case 'D':
+			res.Download, res.DownloadBytes, err = c.download(ctx)
+		case 'U':
+			res.Upload, res.UploadBytes, err = c.upload(ctx)
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+
+	if c.opts.Telemetry {
+		id, err := c.sendTelemetry(ctx, res)
+		if err != nil {
+			return res, fmt.Errorf("cannot send telemetry: %w", err)
+		}
+		res.ID = id
+	}
+	return res, nil
+}
+
+// url resolves path against the server URL and adds the cache busting and session parameters
+func (c *Client) url(path string, params url.Values) string {
+	u := c.base.ResolveReference(&url.URL{Path: strings.TrimPrefix(path, "/")})
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("r", strconv.FormatFloat(rand.Float64(), 'f', -1, 64))
+	if c.session != "" {
+		params.Set("session", c.session)
+	}
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+func (c *Client) request(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.opts.UserAgent)
+	return req, nil
+}
+
+// get performs a short request with the client timeout and returns the response body
+func (c *Client) get(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	req, err := c.request(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", req.URL.Path, resp.Status)
+	}
+	return body, nil
+}
+
+// newSession requests a test session ID. Like the worker, tests run without session if
+// the backend doesn't issue them, e.g. the PHP backend.
+func (c *Client) newSession(ctx context.Context) {
+	if c.opts.SessionURL == "" {
+		return
+	}
+
+	body, err := c.get(ctx, c.url(c.opts.SessionURL, nil))
+	if err != nil {
+		log.Debugf("Running without test session: %s", err)
+		return
+	}
+	var resp struct {
+		Session string `json:"session"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		log.Debugf("Running without test session: %s", err)
+		return
+	}
+	c.session = resp.Session
+}
+
+func (c *Client) getIP(ctx context.Context, res *Result) error {
+	body, err := c.get(ctx, c.url(c.opts.GetIPURL, url.Values{"isp": {"true"}}))
+	if err != nil {
+		return fmt.Errorf("cannot get IP address: %w", err)
+	}
+
+	var resp struct {
+		ProcessedString string `json:"processedString"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		// older backends answer with the plain address
+		res.IP = strings.TrimSpace(string(body))
+		return nil
+	}
+	res.IP = resp.ProcessedString
+	res.ispInfo = string(body)
+	return nil
+}
+
+// sendTelemetry reports the result and returns the test ID assigned by the backend
+func (c *Client) sendTelemetry(ctx context.Context, res *Result) (string, error) {
+	form := url.Values{
+		"ispinfo": {res.ispInfo},
+		"dl":      {formatMeasurement(res.Download, strings.ContainsRune(c.opts.Tests, 'D'))},
+		"ul":      {formatMeasurement(res.Upload, strings.ContainsRune(c.opts.Tests, 'U'))},
+		"ping":    {formatMeasurement(res.Ping, strings.ContainsRune(c.opts.Tests, 'P'))},
+		"jitter":  {formatMeasurement(res.Jitter, strings.ContainsRune(c.opts.Tests, 'P'))},
+		"log":     {""},
+		"extra":   {c.opts.TelemetryExtra},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+	req, err := c.request(ctx, http.MethodPost, c.url(c.opts.TelemetryURL, nil), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", req.URL.Path, resp.Status)
+	}
+
+	id, ok := strings.CutPrefix(strings.TrimSpace(string(body)), "id ")
+	if !ok {
+		return "", fmt.Errorf("unexpected response %q", body)
+	}
+	return id, nil
+}
+
+// formatMeasurement formats values like the worker, tests that were not run are reported empty
+func formatMeasurement(v float64, run bool) string {
+	if !run {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Options)
+		err    string
+	}{
+		{"defaults", func(o *Options) {}, ""},
+		{"server path", func(o *Options) { o.Server = "https://example.com/speedtest" }, ""},
+		{"invalid URL", func(o *Options) { o.Server = "http://[::1" }, "invalid server URL"},
+		{"missing scheme", func(o *Options) { o.Server = "example.com" }, "scheme must be http or https"},
+		{"unsupported scheme", func(o *Options) { o.Server = "ftp://example.com" }, "scheme must be http or https"},
+		{"unknown test", func(o *Options) { o.Tests = "IPX" }, "unknown test"},
+		{"lowercase test", func(o *Options) { o.Tests = "d" }, "unknown test"},
+		{"no tests", func(o *Options) { o.Tests = "" }, ""},
+		{"no download streams", func(o *Options) { o.DownloadStreams = 0 }, "stream counts"},
+		{"no upload streams", func(o *Options) { o.UploadStreams = 0 }, "stream counts"},
+		{"no chunk size", func(o *Options) { o.ChunkSize = 0 }, "sizes must be at least 1 MiB"},
+		{"no upload size", func(o *Options) { o.UploadSize = 0 }, "sizes must be at least 1 MiB"},
+		{"too few pings", func(o *Options) { o.Pings = 2 }, "at least 3 pings"},
+		{"minimum pings", func(o *Options) { o.Pings = 3 }, ""},
+	}
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.Server = "http://example.com"
+		tt.modify(&opts)
+
+		_, err := New(opts)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		case tt.err != "" && err == nil:
+			t.Errorf("%s: expected error %q", tt.name, tt.err)
+		case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+			t.Errorf("%s: error %q doesn't contain %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestURL(t *testing.T) {
+	tests := []struct {
+		server string
+		path   string
+		want   string
+	}{
+		{"http://example.com", "backend/empty.php", "http://example.com/backend/empty.php"},
+		{"http://example.com/speedtest", "backend/empty.php", "http://example.com/speedtest/backend/empty.php"},
+		{"http://example.com/speedtest/", "/garbage", "http://example.com/speedtest/garbage"},
+	}
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.Server = tt.server
+		c, err := New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.session = "abc"
+
+		u, err := url.Parse(c.url(tt.path, url.Values{"ckSize": {"10"}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := u.Query()
+		u.RawQuery = ""
+		if u.String() != tt.want {
+			t.Errorf("url(%s) of %s = %s, want %s", tt.path, tt.server, u, tt.want)
+		}
+		if query.Get("r") == "" || query.Get("session") != "abc" || query.Get("ckSize") != "10" {
+			t.Errorf("url(%s) has query %v", tt.path, query)
+		}
+	}
+}
+
+func TestFormatMeasurement(t *testing.T) {
+	tests := []struct {
+		v    float64
+		run  bool
+		want string
+	}{
+		{94.123, true, "94.12"},
+		{0, true, "0.00"},
+		{12.5, false, ""},
+	}
+	for _, tt := range tests {
+		if got := formatMeasurement(tt.v, tt.run); got != tt.want {
+			t.Errorf("formatMeasurement(%v, %v) = %q, want %q", tt.v, tt.run, got, tt.want)
+		}
+	}
+}
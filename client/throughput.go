@@ -0,0 +1,203 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librespeed/speedtest/pingstats"
+)
+
+const (
+	// streams are started this far apart so that they don't all end at the same time
+	streamDelay = 300 * time.Millisecond
+	// failed streams are reopened after this pause, so that a failing server isn't flooded
+	streamRetryDelay = 200 * time.Millisecond
+)
+
+// stream transfers test data until it is done or ctx ends, adding the bytes to transferred
+type stream func(ctx context.Context, transferred *atomic.Int64) error
+
+// measure runs streams in parallel, restarting them when they are done or have failed, and
+// returns the throughput in Mbit/s and the bytes transferred after the grace time. It fails
+// only if no stream succeeded.
+func (c *Client) measure(parent context.Context, name string, streams int, duration, grace time.Duration, s stream) (float64, int64, error) {
+	ctx, cancel := context.WithTimeout(parent, grace+duration)
+	defer cancel()
+
+	var (
+		transferred atomic.Int64
+		wg          sync.WaitGroup
+		succeeded   atomic.Bool
+		lock        sync.Mutex
+		failures    int
+		lastErr     error
+	)
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(delay time.Duration) {
+			defer wg.Done()
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+
+			for ctx.Err() == nil {
+				// transfers cut short by the end of the test count as successful
+				err := s(ctx, &transferred)
+				if err == nil || ctx.Err() != nil {
+					succeeded.Store(true)
+					continue
+				}
+
+				lock.Lock()
+				failures++
+				lastErr = err
+				lock.Unlock()
+				select {
+				case <-time.After(streamRetryDelay):
+				case <-ctx.Done():
+				}
+			}
+		}(time.Duration(i) * streamDelay)
+	}
+
+	select {
+	case <-time.After(grace):
+	case <-ctx.Done():
+	}
+	start, startBytes := time.Now(), transferred.Load()
+	<-ctx.Done()
+	wg.Wait()
+	elapsed, measured := time.Since(start), transferred.Load()-startBytes
+
+	if err := parent.Err(); err != nil {
+		return 0, 0, err
+	}
+	if failures > 0 && !succeeded.Load() {
+		return 0, 0, fmt.Errorf("%s test failed: %w", name, lastErr)
+	} else if failures > 0 {
+		log.Warnf("%d %s streams failed and were reopened: %s", failures, name, lastErr)
+	}
+	if elapsed <= 0 {
+		return 0, measured, nil
+	}
+	return float64(measured) * 8 * c.opts.OverheadFactor / elapsed.Seconds() / 1000000, measured, nil
+}
+
+func (c *Client) download(ctx context.Context) (float64, int64, error) {
+	return c.measure(ctx, "download", c.opts.DownloadStreams, c.opts.DownloadDuration, c.opts.DownloadGraceTime, c.downloadStream)
+}
+
+func (c *Client) downloadStream(ctx context.Context, transferred *atomic.Int64) error {
+	req, err := c.request(ctx, http.MethodGet, c.url(c.opts.DownloadURL, url.Values{"ckSize": {strconv.Itoa(c.opts.ChunkSize)}}), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Path, resp.Status)
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		transferred.Add(int64(n))
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) upload(ctx context.Context) (float64, int64, error) {
+	// incompressible data, like the blobs of the worker
+	data := make([]byte, c.opts.UploadSize*1048576)
+	if _, err := rand.Read(data); err != nil {
+		return 0, 0, err
+	}
+
+	return c.measure(ctx, "upload", c.opts.UploadStreams, c.opts.UploadDuration, c.opts.UploadGraceTime,
+		func(ctx context.Context, transferred *atomic.Int64) error {
+			return c.uploadStream(ctx, data, transferred)
+		})
+}
+
+// uploadStream posts data once. The bytes handed to the transport are counted live, like
+// the upload progress events of XHRs, and discounted again if the server doesn't accept
+// the upload. Uploads cut short by the end of the test count.
+func (c *Client) uploadStream(ctx context.Context, data []byte, transferred *atomic.Int64) (err error) {
+	body := &countingReader{r: bytes.NewReader(data), transferred: transferred}
+	defer func() {
+		if err != nil && ctx.Err() == nil {
+			transferred.Add(-body.n.Load())
+		}
+	}()
+
+	req, err := c.request(ctx, http.MethodPost, c.url(c.opts.UploadURL, nil), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Path, resp.Status)
+	}
+	return nil
+}
+
+// countingReader adds the bytes read to transferred and remembers them in n. The
+// transport may still read after Do returned, so n is atomic too.
+type countingReader struct {
+	r           io.Reader
+	transferred *atomic.Int64
+	n           atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	c.transferred.Add(int64(n))
+	return n, err
+}
+
+// ping times sequential requests to the ping URL. The first one opens the connection and
+// is not measured.
+func (c *Client) ping(ctx context.Context) (float64, float64, error) {
+	var rtts []float64
+	for i := 0; i < c.opts.Pings; i++ {
+		start := time.Now()
+		if _, err := c.get(ctx, c.url(c.opts.PingURL, nil)); err != nil {
+			return 0, 0, fmt.Errorf("ping test failed: %w", err)
+		}
+		if i > 0 {
+			rtts = append(rtts, float64(time.Since(start))/float64(time.Millisecond))
+		}
+	}
+
+	ping, jitter := pingstats.Compute(rtts)
+	return ping, jitter, nil
+}
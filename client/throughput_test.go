@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUploadStreamCounting(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   int64
+	}{
+		{"accepted", http.StatusOK, 1024},
+		{"rejected", http.StatusTooManyRequests, 0},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			w.WriteHeader(tt.status)
+		}))
+
+		opts := DefaultOptions()
+		opts.Server = srv.URL
+		c, err := New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var transferred atomic.Int64
+		err = c.uploadStream(context.Background(), make([]byte, 1024), &transferred)
+		if (err == nil) != (tt.status == http.StatusOK) {
+			t.Errorf("%s: uploadStream() = %v", tt.name, err)
+		}
+		if got := transferred.Load(); got != tt.want {
+			t.Errorf("%s: transferred %d bytes, want %d", tt.name, got, tt.want)
+		}
+		srv.Close()
+	}
+}
+
+func TestMeasureReopensFailedStreams(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Server = "http://192.0.2.1"
+	c, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the stream fails on every other attempt
+	var attempts atomic.Int32
+	flaky := func(ctx context.Context, transferred *atomic.Int64) error {
+		if attempts.Add(1)%2 == 1 {
+			return errors.New("connection reset")
+		}
+		transferred.Add(1000)
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	_, bytes, err := c.measure(context.Background(), "download", 1, 600*time.Millisecond, 0, flaky)
+	if err != nil {
+		t.Fatalf("measure() = %v", err)
+	}
+	if n := attempts.Load(); n < 3 || bytes == 0 {
+		t.Errorf("%d attempts transferred %d bytes, want the stream to be reopened", n, bytes)
+	}
+
+	failing := func(ctx context.Context, transferred *atomic.Int64) error {
+		return errors.New("connection refused")
+	}
+	if _, _, err := c.measure(context.Background(), "upload", 2, 300*time.Millisecond, 0, failing); err == nil {
+		t.Error("measure() with failing streams succeeded")
+	}
+}
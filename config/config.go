@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -14,9 +16,38 @@ type Config struct {
 	ServerLng         float64 `mapstructure:"server_lng"`
 	IPInfoAPIKey      string  `mapstructure:"ipinfo_api_key"`
 
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+
+	ISPProvider    string `mapstructure:"isp_provider"`
+	ISPProviderURL string `mapstructure:"isp_provider_url"`
+	ISPStaticFile  string `mapstructure:"isp_static_file"`
+
+	SiteMappingFile string `mapstructure:"site_mapping_file"`
+
+	GeoIPCityDatabase string `mapstructure:"geoip_city_database"`
+	GeoIPASNDatabase  string `mapstructure:"geoip_asn_database"`
+	IPInfoFallback    bool   `mapstructure:"ipinfo_fallback"`
+
+	ISPLookupTimeout    time.Duration `mapstructure:"isp_lookup_timeout"`
+	ISPCacheSize        int           `mapstructure:"isp_cache_size"`
+	ISPCacheTTL         time.Duration `mapstructure:"isp_cache_ttl"`
+	ISPCacheNegativeTTL time.Duration `mapstructure:"isp_cache_negative_ttl"`
+	ISPCacheIPv4Prefix  int           `mapstructure:"isp_cache_ipv4_prefix"`
+	ISPCacheIPv6Prefix  int           `mapstructure:"isp_cache_ipv6_prefix"`
+
 	StatsPassword string `mapstructure:"statistics_password"`
 	RedactIP      bool   `mapstructure:"redact_ip_addresses"`
 
+	ImplausibleSpeedFactor float64 `mapstructure:"implausible_speed_factor"`
+	RequireSession         bool    `mapstructure:"require_session"`
+
+	RateLimitIPStreams               int     `mapstructure:"rate_limit_ip_streams"`
+	RateLimitIPMiBPerMinute          int64   `mapstructure:"rate_limit_ip_mib_per_minute"`
+	RateLimitIPRequestsPerSecond     float64 `mapstructure:"rate_limit_ip_requests_per_second"`
+	RateLimitGlobalStreams           int     `mapstructure:"rate_limit_global_streams"`
+	RateLimitGlobalMiBPerMinute      int64   `mapstructure:"rate_limit_global_mib_per_minute"`
+	RateLimitGlobalRequestsPerSecond float64 `mapstructure:"rate_limit_global_requests_per_second"`
+
 	AssetsPath string `mapstructure:"assets_path"`
 
 	DatabaseType     string `mapstructure:"database_type"`
@@ -26,11 +57,64 @@ type Config struct {
 	DatabasePassword string `mapstructure:"database_password"`
 
 	DatabaseFile string `mapstructure:"database_file"`
+	// MemoryMaxRecords bounds the memory database, the oldest records are dropped first
+	MemoryMaxRecords int `mapstructure:"memory_max_records"`
+
+	EnableMetrics bool `mapstructure:"enable_metrics"`
+	// MetricsListen serves the metrics on a separate address instead of the public listeners
+	MetricsListen string `mapstructure:"metrics_listen"`
 
 	EnableHTTP2 bool   `mapstructure:"enable_http2"`
+	EnableHTTP3 bool   `mapstructure:"enable_http3"`
 	EnableTLS   bool   `mapstructure:"enable_tls"`
 	TLSCertFile string `mapstructure:"tls_cert_file"`
 	TLSKeyFile  string `mapstructure:"tls_key_file"`
+
+	ACME                bool     `mapstructure:"acme"`
+	ACMEDomains         []string `mapstructure:"acme_domains"`
+	ACMEEmail           string   `mapstructure:"acme_email"`
+	ACMEDirectoryURL    string   `mapstructure:"acme_directory_url"`
+	ACMEDirectoryCAFile string   `mapstructure:"acme_directory_ca_file"`
+	ACMECacheDir        string   `mapstructure:"acme_cache_dir"`
+	ACMEHTTPAddress     string   `mapstructure:"acme_http_address"`
+
+	Listeners []Listener `mapstructure:"listeners"`
+
+	Servers                    []Server      `mapstructure:"servers"`
+	ServersHealthCheckInterval time.Duration `mapstructure:"servers_health_check_interval"`
+}
+
+// Listener is an address to serve on, configured with a [[listeners]] table. Instead of
+// binding Address, a socket inherited through systemd socket activation can be used by
+// its FileDescriptorName.
+type Listener struct {
+	Address       string `mapstructure:"address"`
+	Network       string `mapstructure:"network"`
+	SystemdName   string `mapstructure:"systemd_name"`
+	TLS           bool   `mapstructure:"tls"`
+	HTTP2         bool   `mapstructure:"http2"`
+	HTTP3         bool   `mapstructure:"http3"`
+	ProxyProtocol bool   `mapstructure:"proxy_protocol"`
+}
+
+// Server is a test server listed in /servers.json for multi-server frontends, configured
+// with a [[servers]] table. Empty paths default to the routes of this backend, SessionURL,
+// LatencyURL and LoadedLatencyURL only if DownloadURL is empty too.
+type Server struct {
+	ID               int    `mapstructure:"id"`
+	Name             string `mapstructure:"name"`
+	Server           string `mapstructure:"server"`
+	Location         string `mapstructure:"location"`
+	SponsorName      string `mapstructure:"sponsor_name"`
+	SponsorURL       string `mapstructure:"sponsor_url"`
+	DownloadURL      string `mapstructure:"dl_url"`
+	UploadURL        string `mapstructure:"ul_url"`
+	PingURL          string `mapstructure:"ping_url"`
+	GetIPURL         string `mapstructure:"get_ip_url"`
+	SessionURL       string `mapstructure:"session_url"`
+	LatencyURL       string `mapstructure:"latency_url"`
+	LoadedLatencyURL string `mapstructure:"loaded_latency_url"`
+	HealthURL        string `mapstructure:"health_url"`
 }
 
 var (
@@ -42,17 +126,48 @@ func init() {
 	viper.SetDefault("listen_port", "8989")
 	viper.SetDefault("url_base", "")
 	viper.SetDefault("proxyprotocol_port", "0")
+	viper.SetDefault("shutdown_timeout", "30s")
+	viper.SetDefault("servers_health_check_interval", "0s")
 	viper.SetDefault("download_chunks", 4)
 	viper.SetDefault("distance_unit", "K")
 	viper.SetDefault("enable_cors", false)
+	viper.SetDefault("isp_provider", "")
+	viper.SetDefault("isp_provider_url", "")
+	viper.SetDefault("isp_static_file", "")
+	viper.SetDefault("site_mapping_file", "")
+	viper.SetDefault("geoip_city_database", "")
+	viper.SetDefault("geoip_asn_database", "")
+	viper.SetDefault("ipinfo_fallback", false)
+	viper.SetDefault("isp_lookup_timeout", "5s")
+	viper.SetDefault("isp_cache_size", 10000)
+	viper.SetDefault("isp_cache_ttl", "24h")
+	viper.SetDefault("isp_cache_negative_ttl", "5m")
+	viper.SetDefault("isp_cache_ipv4_prefix", 32)
+	viper.SetDefault("isp_cache_ipv6_prefix", 128)
 	viper.SetDefault("statistics_password", "PASSWORD")
 	viper.SetDefault("redact_ip_addresses", false)
+	viper.SetDefault("implausible_speed_factor", 2.0)
+	viper.SetDefault("require_session", false)
+	viper.SetDefault("rate_limit_ip_streams", 0)
+	viper.SetDefault("rate_limit_ip_mib_per_minute", 0)
+	viper.SetDefault("rate_limit_ip_requests_per_second", 0)
+	viper.SetDefault("rate_limit_global_streams", 0)
+	viper.SetDefault("rate_limit_global_mib_per_minute", 0)
+	viper.SetDefault("rate_limit_global_requests_per_second", 0)
 	viper.SetDefault("database_type", "postgresql")
 	viper.SetDefault("database_hostname", "localhost")
 	viper.SetDefault("database_name", "speedtest")
 	viper.SetDefault("database_username", "postgres")
+	viper.SetDefault("memory_max_records", 100)
 	viper.SetDefault("enable_tls", false)
 	viper.SetDefault("enable_http2", false)
+	viper.SetDefault("enable_http3", false)
+	viper.SetDefault("enable_metrics", false)
+	viper.SetDefault("metrics_listen", "")
+	viper.SetDefault("acme", false)
+	viper.SetDefault("acme_directory_url", "https://acme-v02.api.letsencrypt.org/directory")
+	viper.SetDefault("acme_cache_dir", "acme-cache")
+	viper.SetDefault("acme_http_address", "")
 
 	viper.SetConfigName("settings")
 	viper.AddConfigPath(".")
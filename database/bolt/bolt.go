@@ -1,8 +1,14 @@
 package bolt
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/librespeed/speedtest/database/schema"
@@ -12,7 +18,12 @@ import (
 )
 
 const (
-	bucketName = `speedtest`
+	bucketName       = `speedtest`
+	metaBucketName   = `meta`
+	schemaVersionKey = `schema_version`
+
+	// bump when the stored JSON layout changes, and add a step to migrate()
+	schemaVersion = 2
 )
 
 type Bolt struct {
@@ -24,7 +35,84 @@ func Open(databaseFile string) *Bolt {
 	if err != nil {
 		log.Fatalf("Cannot open BoltDB database file: %s", err)
 	}
-	return &Bolt{db: db}
+	p := &Bolt{db: db}
+	if err := p.migrate(); err != nil {
+		log.Fatalf("Cannot migrate BoltDB database: %s", err)
+	}
+	return p
+}
+
+func (p *Bolt) migrate() error {
+	return p.db.Update(func(tx *bbolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(metaBucketName))
+		if err != nil {
+			return err
+		}
+
+		// databases created before versioning was introduced have no version key
+		version := 1
+		if v := meta.Get([]byte(schemaVersionKey)); v != nil {
+			if version, err = strconv.Atoi(string(v)); err != nil {
+				return fmt.Errorf("invalid schema version %q: %s", v, err)
+			}
+		}
+
+		if version < 2 {
+			log.Info("Converting stored measurements to numeric values")
+			if err := migrateNumericMeasurements(tx); err != nil {
+				return err
+			}
+		}
+
+		return meta.Put([]byte(schemaVersionKey), []byte(strconv.Itoa(schemaVersion)))
+	})
+}
+
+// migrateNumericMeasurements rewrites records stored with string Download, Upload, Ping
+// and Jitter values. Values that cannot be parsed (e.g. "Fail") are stored as 0.
+func migrateNumericMeasurements(tx *bbolt.Tx) error {
+	bucket := tx.Bucket([]byte(bucketName))
+	if bucket == nil {
+		return nil
+	}
+
+	updated := make(map[string][]byte)
+	err := bucket.ForEach(func(k, v []byte) error {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(v, &fields); err != nil {
+			return err
+		}
+
+		for _, name := range []string{"Download", "Upload", "Ping", "Jitter"} {
+			var str string
+			if err := json.Unmarshal(fields[name], &str); err != nil {
+				// already numeric
+				continue
+			}
+			f, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || f < 0 {
+				f = 0
+			}
+			fields[name], _ = json.Marshal(f)
+		}
+
+		b, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		updated[string(k)] = b
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for k, v := range updated {
+		if err := bucket.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (p *Bolt) Insert(data *schema.TelemetryData) error {
@@ -52,31 +140,107 @@ func (p *Bolt) FetchByUUID(uuid string) (*schema.TelemetryData, error) {
 	return &record, err
 }
 
-func (p *Bolt) FetchLast100() ([]schema.TelemetryData, error) {
+// Query walks the bucket in key order. Keys are ULIDs, which sort by creation time, so
+// the cursor can seek straight to the last record of the previous page.
+func (p *Bolt) Query(q schema.Query) (*schema.Page, error) {
+	var afterKey []byte
+	if q.Cursor != "" {
+		_, uuid, err := schema.DecodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		afterKey = []byte(uuid)
+	}
+
 	var records []schema.TelemetryData
 	err := p.db.View(func(tx *bbolt.Tx) error {
-		var record schema.TelemetryData
 		bucket := tx.Bucket([]byte(bucketName))
 		if bucket == nil {
-			return errors.New("data bucket doesn't exist yet")
+			return nil
 		}
 
 		cursor := bucket.Cursor()
-		_, b := cursor.Last()
+		next := cursor.Prev
+		if q.Order == schema.OldestFirst {
+			next = cursor.Next
+		}
 
-		for len(records) < 100 {
+		var k, b []byte
+		switch {
+		case afterKey == nil && q.Order == schema.OldestFirst:
+			k, b = cursor.First()
+		case afterKey == nil:
+			k, b = cursor.Last()
+		default:
+			k, b = cursor.Seek(afterKey)
+			switch {
+			case q.Order == schema.OldestFirst && bytes.Equal(k, afterKey):
+				k, b = cursor.Next()
+			case q.Order == schema.NewestFirst && k == nil:
+				k, b = cursor.Last()
+			case q.Order == schema.NewestFirst:
+				k, b = cursor.Prev()
+			}
+		}
+
+		for ; k != nil && len(records) <= q.PageSize(); k, b = next() {
+			var record schema.TelemetryData
 			if err := json.Unmarshal(b, &record); err != nil {
 				return err
 			}
-			records = append(records, record)
 
-			_, b = cursor.Prev()
-			if b == nil {
+			// stop early once past the requested time range
+			if q.Order == schema.NewestFirst && !q.From.IsZero() && record.Timestamp.Before(q.From) {
+				break
+			}
+			if q.Order == schema.OldestFirst && !q.To.IsZero() && !record.Timestamp.Before(q.To) {
 				break
 			}
+
+			if q.Matches(&record) {
+				records = append(records, record)
+			}
 		}
 
 		return nil
 	})
-	return records, err
+	if err != nil {
+		return nil, err
+	}
+	return q.NewPage(records), nil
+}
+
+func (p *Bolt) Aggregate(q schema.AggregateQuery) ([]schema.Aggregate, error) {
+	aggregator := schema.NewAggregator(q)
+	err := p.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(_, b []byte) error {
+			var record schema.TelemetryData
+			if err := json.Unmarshal(b, &record); err != nil {
+				return err
+			}
+			if q.Matches(&record) {
+				aggregator.Add(&record)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return aggregator.Result(), nil
+}
+
+func (p *Bolt) Ping(_ context.Context) error {
+	return p.db.View(func(tx *bbolt.Tx) error {
+		return nil
+	})
+}
+
+func (p *Bolt) Close() error {
+	return p.db.Close()
 }
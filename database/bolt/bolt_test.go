@@ -0,0 +1,131 @@
+package bolt
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest/database/schema"
+
+	"go.etcd.io/bbolt"
+)
+
+var base = time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+
+func openTemp(t *testing.T) *Bolt {
+	t.Helper()
+	db := Open(filepath.Join(t.TempDir(), "speedtest.bolt"))
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// putRecords stores records as they are, as Insert would overwrite the timestamps
+func putRecords(t *testing.T, db *Bolt, records []schema.TelemetryData) {
+	t.Helper()
+	err := db.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+		if err != nil {
+			return err
+		}
+		for i := range records {
+			b, _ := json.Marshal(&records[i])
+			if err := bucket.Put([]byte(records[i].UUID), b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pageThrough follows the cursors of q and returns the UUIDs of all records
+func pageThrough(t *testing.T, db *Bolt, q schema.Query) string {
+	t.Helper()
+	var uuids []string
+	for {
+		page, err := db.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range page.Records {
+			uuids = append(uuids, r.UUID)
+		}
+		if page.NextCursor == "" {
+			return strings.Join(uuids, ",")
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+func TestQuery(t *testing.T) {
+	db := openTemp(t)
+	// keys are ULIDs in production, which sort like the timestamps
+	putRecords(t, db, []schema.TelemetryData{
+		{Timestamp: base, UUID: "01", IPAddress: "192.0.2.1", UserAgent: "Firefox"},
+		{Timestamp: base.Add(time.Minute), UUID: "02", IPAddress: "192.0.2.1", UserAgent: "curl", ISPInfo: "Example ISP"},
+		{Timestamp: base.Add(time.Hour), UUID: "03", IPAddress: "198.51.100.1", UserAgent: "Firefox"},
+		{Timestamp: base.Add(time.Hour + time.Minute), UUID: "04", IPAddress: "192.0.2.1", UserAgent: "firefox"},
+		{Timestamp: base.Add(2 * time.Hour), UUID: "05", IPAddress: "192.0.2.1", UserAgent: "Firefox"},
+	})
+
+	tests := []struct {
+		name string
+		q    schema.Query
+		want string
+	}{
+		{"newest first", schema.Query{Limit: 2}, "05,04,03,02,01"},
+		{"oldest first", schema.Query{Order: schema.OldestFirst, Limit: 2}, "01,02,03,04,05"},
+		{"single page", schema.Query{}, "05,04,03,02,01"},
+		{"ip address", schema.Query{IPAddress: "192.0.2.1", Limit: 1}, "05,04,02,01"},
+		{"user agent", schema.Query{UserAgent: "FIREFOX", Order: schema.OldestFirst, Limit: 3}, "01,03,04,05"},
+		{"isp", schema.Query{ISP: "example"}, "02"},
+		{"time range newest first", schema.Query{From: base.Add(time.Minute), To: base.Add(2 * time.Hour), Limit: 2}, "04,03,02"},
+		{"time range oldest first", schema.Query{From: base.Add(time.Minute), To: base.Add(2 * time.Hour), Order: schema.OldestFirst, Limit: 2}, "02,03,04"},
+	}
+	for _, tt := range tests {
+		if got := pageThrough(t, db, tt.q); got != tt.want {
+			t.Errorf("%s: paged through %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// the record of a cursor may have been deleted in the meantime
+	for _, tt := range []struct {
+		order schema.SortOrder
+		uuid  string
+		want  string
+	}{
+		{schema.NewestFirst, "035", "03"},
+		{schema.OldestFirst, "035", "04"},
+		{schema.NewestFirst, "06", "05"},
+		{schema.OldestFirst, "00", "01"},
+	} {
+		cursor := schema.EncodeCursor(&schema.TelemetryData{UUID: tt.uuid})
+		page, err := db.Query(schema.Query{Order: tt.order, Cursor: cursor, Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Records) != 1 || page.Records[0].UUID != tt.want {
+			t.Errorf("page after %s = %v, want %s", tt.uuid, page.Records, tt.want)
+		}
+	}
+
+	if _, err := db.Query(schema.Query{Cursor: "%"}); !errors.Is(err, schema.ErrInvalidCursor) {
+		t.Errorf("Query() with malformed cursor = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestQueryEmpty(t *testing.T) {
+	db := openTemp(t)
+	page, err := db.Query(schema.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Records) != 0 || page.NextCursor != "" {
+		t.Errorf("Query() on empty database = %+v", page)
+	}
+}
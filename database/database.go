@@ -1,6 +1,8 @@
 package database
 
 import (
+	"context"
+
 	"github.com/librespeed/speedtest/config"
 	"github.com/librespeed/speedtest/database/bolt"
 	"github.com/librespeed/speedtest/database/memory"
@@ -8,6 +10,7 @@ import (
 	"github.com/librespeed/speedtest/database/none"
 	"github.com/librespeed/speedtest/database/postgresql"
 	"github.com/librespeed/speedtest/database/schema"
+	"github.com/librespeed/speedtest/database/sqlite"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -19,7 +22,12 @@ var (
 type DataAccess interface {
 	Insert(*schema.TelemetryData) error
 	FetchByUUID(string) (*schema.TelemetryData, error)
-	FetchLast100() ([]schema.TelemetryData, error)
+	Query(schema.Query) (*schema.Page, error)
+	Aggregate(schema.AggregateQuery) ([]schema.Aggregate, error)
+	// Ping checks that the database is reachable before ctx ends
+	Ping(ctx context.Context) error
+	// Close flushes pending writes and releases the database, no other method may be called afterwards
+	Close() error
 }
 
 func SetDBInfo(conf *config.Config) {
@@ -30,11 +38,15 @@ func SetDBInfo(conf *config.Config) {
 		DB = mysql.Open(conf.DatabaseHostname, conf.DatabaseUsername, conf.DatabasePassword, conf.DatabaseName)
 	case "bolt":
 		DB = bolt.Open(conf.DatabaseFile)
+	case "sqlite":
+		DB = sqlite.Open(conf.DatabaseFile)
 	case "memory":
-		DB = memory.Open("")
+		DB = memory.Open(conf.MemoryMaxRecords)
 	case "none":
 		DB = none.Open("")
 	default:
 		log.Fatalf("Unsupported database type: %s", conf.DatabaseType)
 	}
+
+	DB = &instrumented{backend: conf.DatabaseType, db: DB}
 }
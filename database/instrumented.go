@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/librespeed/speedtest/database/schema"
+	"github.com/librespeed/speedtest/metrics"
+)
+
+// instrumented records latency and errors of the wrapped backend's operations
+type instrumented struct {
+	backend string
+	db      DataAccess
+}
+
+func (i *instrumented) Insert(data *schema.TelemetryData) error {
+	start := time.Now()
+	err := i.db.Insert(data)
+	metrics.ObserveDatabase(i.backend, "insert", start, err)
+	return err
+}
+
+func (i *instrumented) FetchByUUID(uuid string) (*schema.TelemetryData, error) {
+	start := time.Now()
+	record, err := i.db.FetchByUUID(uuid)
+	metrics.ObserveDatabase(i.backend, "fetch_by_uuid", start, err)
+	return record, err
+}
+
+func (i *instrumented) Query(q schema.Query) (*schema.Page, error) {
+	start := time.Now()
+	page, err := i.db.Query(q)
+	metrics.ObserveDatabase(i.backend, "query", start, err)
+	return page, err
+}
+
+func (i *instrumented) Aggregate(q schema.AggregateQuery) ([]schema.Aggregate, error) {
+	start := time.Now()
+	aggregates, err := i.db.Aggregate(q)
+	metrics.ObserveDatabase(i.backend, "aggregate", start, err)
+	return aggregates, err
+}
+
+func (i *instrumented) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := i.db.Ping(ctx)
+	metrics.ObserveDatabase(i.backend, "ping", start, err)
+	return err
+}
+
+func (i *instrumented) Close() error {
+	return i.db.Close()
+}
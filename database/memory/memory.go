@@ -1,6 +1,7 @@
 package memory
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -9,27 +10,36 @@ import (
 )
 
 const (
-	// just enough records to return for FetchLast100
-	maxRecords = 100
+	// used if no positive limit is configured
+	defaultMaxRecords = 100
 )
 
+// Memory keeps the latest results in a ring buffer, as it has no other bound
 type Memory struct {
-	lock    sync.RWMutex
-	records []schema.TelemetryData
+	lock       sync.RWMutex
+	maxRecords int
+	records    []schema.TelemetryData
+	// next is the position of the oldest record, which is overwritten once the buffer is full
+	next int
 }
 
-func Open(_ string) *Memory {
-	return &Memory{}
+func Open(maxRecords int) *Memory {
+	if maxRecords <= 0 {
+		maxRecords = defaultMaxRecords
+	}
+	return &Memory{maxRecords: maxRecords}
 }
 
 func (mem *Memory) Insert(data *schema.TelemetryData) error {
 	mem.lock.Lock()
 	defer mem.lock.Unlock()
 	data.Timestamp = time.Now()
-	mem.records = append(mem.records, *data)
-	if len(mem.records) > maxRecords {
-		mem.records = mem.records[len(mem.records)-maxRecords:]
+	if len(mem.records) < mem.maxRecords {
+		mem.records = append(mem.records, *data)
+		return nil
 	}
+	mem.records[mem.next] = *data
+	mem.next = (mem.next + 1) % mem.maxRecords
 	return nil
 }
 
@@ -44,8 +54,29 @@ func (mem *Memory) FetchByUUID(uuid string) (*schema.TelemetryData, error) {
 	return nil, errors.New("record not found")
 }
 
-func (mem *Memory) FetchLast100() ([]schema.TelemetryData, error) {
+func (mem *Memory) Query(q schema.Query) (*schema.Page, error) {
 	mem.lock.RLock()
 	defer mem.lock.RUnlock()
-	return mem.records, nil
+	return q.Apply(mem.records)
+}
+
+func (mem *Memory) Aggregate(q schema.AggregateQuery) ([]schema.Aggregate, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	aggregator := schema.NewAggregator(q)
+	for i := range mem.records {
+		if q.Matches(&mem.records[i]) {
+			aggregator.Add(&mem.records[i])
+		}
+	}
+	return aggregator.Result(), nil
+}
+
+func (mem *Memory) Ping(_ context.Context) error {
+	return nil
+}
+
+func (mem *Memory) Close() error {
+	return nil
 }
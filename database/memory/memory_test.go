@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest/database/schema"
+)
+
+func TestInsertEvictsOldest(t *testing.T) {
+	mem := Open(3)
+	for i := 0; i < 5; i++ {
+		if err := mem.Insert(&schema.TelemetryData{UUID: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(mem.records) != 3 {
+		t.Fatalf("got %d records, want 3", len(mem.records))
+	}
+	for _, uuid := range []string{"0", "1"} {
+		if _, err := mem.FetchByUUID(uuid); err == nil {
+			t.Errorf("record %s was not evicted", uuid)
+		}
+	}
+	for _, uuid := range []string{"2", "3", "4"} {
+		if _, err := mem.FetchByUUID(uuid); err != nil {
+			t.Errorf("record %s: %s", uuid, err)
+		}
+	}
+}
+
+func TestOpenDefaultLimit(t *testing.T) {
+	if mem := Open(0); mem.maxRecords != defaultMaxRecords {
+		t.Errorf("maxRecords = %d, want %d", mem.maxRecords, defaultMaxRecords)
+	}
+}
+
+func TestQueryPages(t *testing.T) {
+	mem := Open(10)
+	for i := 0; i < 5; i++ {
+		if err := mem.Insert(&schema.TelemetryData{UUID: strconv.Itoa(i), IPAddress: "192.0.2.1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// records inserted within the same clock tick share a timestamp
+	ts := time.Now().UTC()
+	for i := range mem.records {
+		mem.records[i].Timestamp = ts
+	}
+	mem.records[4].IPAddress = "198.51.100.1"
+
+	q := schema.Query{IPAddress: "192.0.2.1", Order: schema.OldestFirst, Limit: 3}
+	var uuids []string
+	for {
+		page, err := mem.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range page.Records {
+			uuids = append(uuids, r.UUID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	if got := strings.Join(uuids, ","); got != "0,1,2,3" {
+		t.Errorf("paged through %s, want 0,1,2,3", got)
+	}
+
+	if _, err := mem.Query(schema.Query{Cursor: "%"}); !errors.Is(err, schema.ErrInvalidCursor) {
+		t.Errorf("Query() with malformed cursor = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	mem := Open(10)
+	for _, dl := range []float64{10, 0, 30} {
+		if err := mem.Insert(&schema.TelemetryData{Download: dl}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := mem.Aggregate(schema.AggregateQuery{Period: schema.Day})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("got %d aggregates, want 1", len(result))
+	}
+	if result[0].Count != 3 || result[0].Download.Count != 2 || result[0].Download.Median != 20 {
+		t.Errorf("aggregate = %+v", result[0])
+	}
+
+	result, err = mem.Aggregate(schema.AggregateQuery{Query: schema.Query{To: time.Now().Add(-time.Hour)}, Period: schema.Day})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 0 {
+		t.Errorf("filtered aggregate = %+v, want none", result)
+	}
+}
@@ -0,0 +1,223 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	versionTable          = `speedtest_schema_version`
+	insertVersionTemplate = `INSERT INTO ` + versionTable + ` (version) VALUES (%d)`
+
+	// version assumed for databases that were set up by hand with the .sql files of older
+	// releases, before the schema version table existed
+	legacyVersion = 1
+)
+
+// Migration is a single schema change, applied once in ascending Version order
+type Migration struct {
+	Version     int
+	Description string
+	Statements  []string
+}
+
+// Dialect describes how migrations are run on a database
+type Dialect struct {
+	// Lock and Unlock take and release an advisory lock around the whole run, so that
+	// instances starting at the same time don't apply the same migration twice. Lock has
+	// to return a single row with the value 1 once the lock is held.
+	Lock   string
+	Unlock string
+	// ImplicitCommit is set for databases that commit DDL statements implicitly, like MySQL.
+	// A failed migration can't be rolled back there, so each migration may contain only one
+	// DDL statement, which has to come last, after statements that are safe to repeat.
+	ImplicitCommit bool
+	// AlreadyApplied reports errors of a DDL statement that mean it took effect in an earlier
+	// run, which stopped before the version was recorded, like duplicate columns on MySQL
+	AlreadyApplied func(error) bool
+	// Begin starts a single transaction around the whole run in place of one transaction per
+	// migration. It has to take a write lock before the schema version is read, like
+	// BEGIN IMMEDIATE on SQLite.
+	Begin string
+}
+
+// validate checks that a failed migration can be retried on the next start
+func (d Dialect) validate(m Migration) error {
+	if !d.ImplicitCommit {
+		return nil
+	}
+	for i, stmt := range m.Statements {
+		if isDDL(stmt) && i != len(m.Statements)-1 {
+			return fmt.Errorf("migration %d: DDL statement %d of %d commits implicitly and must be the last one", m.Version, i+1, len(m.Statements))
+		}
+	}
+	return nil
+}
+
+func isDDL(stmt string) bool {
+	fields := strings.Fields(stmt)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "CREATE", "ALTER", "DROP", "RENAME", "TRUNCATE":
+		return true
+	}
+	return false
+}
+
+// Run brings the database schema up to date by applying every migration newer than
+// the version recorded in the database
+func Run(db *sql.DB, d Dialect, migrations []Migration) error {
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for _, m := range migrations {
+		if err := d.validate(m); err != nil {
+			return err
+		}
+	}
+
+	// advisory locks belong to the database session, so everything runs on one connection
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if d.Lock != "" {
+		var locked sql.NullInt64
+		if err := conn.QueryRowContext(ctx, d.Lock).Scan(&locked); err != nil {
+			return fmt.Errorf("cannot lock schema migrations: %s", err)
+		}
+		if locked.Int64 != 1 {
+			return fmt.Errorf("cannot lock schema migrations: timed out waiting for another instance")
+		}
+		defer func() {
+			if _, err := conn.ExecContext(ctx, d.Unlock); err != nil {
+				log.Warnf("Cannot unlock schema migrations: %s", err)
+			}
+		}()
+	}
+
+	if d.Begin != "" {
+		if _, err := conn.ExecContext(ctx, d.Begin); err != nil {
+			return fmt.Errorf("cannot lock schema migrations: %s", err)
+		}
+		committed := false
+		defer func() {
+			if !committed {
+				_, _ = conn.ExecContext(ctx, `ROLLBACK`)
+			}
+		}()
+		if err := migrate(ctx, conn, d, migrations); err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, `COMMIT`); err != nil {
+			return fmt.Errorf("cannot commit schema migrations: %s", err)
+		}
+		committed = true
+		return nil
+	}
+
+	return migrate(ctx, conn, d, migrations)
+}
+
+func migrate(ctx context.Context, conn *sql.Conn, d Dialect, migrations []Migration) error {
+	current, err := currentVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		log.Infof("Applying database migration %d: %s", m.Version, m.Description)
+		if err := apply(ctx, conn, d, m); err != nil {
+			return fmt.Errorf("migration %d failed: %s", m.Version, err)
+		}
+		current = m.Version
+	}
+
+	log.Infof("Database schema is at version %d", current)
+	return nil
+}
+
+func currentVersion(ctx context.Context, conn *sql.Conn) (int, error) {
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+versionTable+` (version INTEGER NOT NULL)`); err != nil {
+		return 0, fmt.Errorf("cannot create schema version table: %s", err)
+	}
+
+	var version sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT MAX(version) FROM `+versionTable).Scan(&version); err != nil {
+		return 0, fmt.Errorf("cannot read schema version: %s", err)
+	}
+	if version.Valid {
+		return int(version.Int64), nil
+	}
+
+	// no version recorded yet, check whether the table was created manually from the .sql files
+	rows, err := conn.QueryContext(ctx, `SELECT 1 FROM speedtest_users WHERE 1 = 0`)
+	if err != nil {
+		return 0, nil
+	}
+	_ = rows.Close()
+
+	log.Infof("Found existing speedtest_users table without schema version, assuming version %d", legacyVersion)
+	if err := setVersion(ctx, conn, legacyVersion); err != nil {
+		return 0, err
+	}
+	return legacyVersion, nil
+}
+
+// execer is implemented by both *sql.Conn and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func apply(ctx context.Context, conn *sql.Conn, d Dialect, m Migration) error {
+	if d.Begin != "" {
+		// the whole run is already one transaction
+		return applyStatements(ctx, conn, d, m)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := applyStatements(ctx, tx, d, m); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func applyStatements(ctx context.Context, ex execer, d Dialect, m Migration) error {
+	for _, stmt := range m.Statements {
+		if _, err := ex.ExecContext(ctx, stmt); err != nil {
+			if isDDL(stmt) && d.AlreadyApplied != nil && d.AlreadyApplied(err) {
+				log.Warnf("Migration %d was already applied without recording its version: %s", m.Version, err)
+				continue
+			}
+			return err
+		}
+	}
+
+	_, err := ex.ExecContext(ctx, fmt.Sprintf(insertVersionTemplate, m.Version))
+	return err
+}
+
+func setVersion(ctx context.Context, conn *sql.Conn, version int) error {
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(insertVersionTemplate, version)); err != nil {
+		return fmt.Errorf("cannot record schema version: %s", err)
+	}
+	return nil
+}
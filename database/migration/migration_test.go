@@ -0,0 +1,231 @@
+package migration
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestDialectValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		statements []string
+		ok         bool
+	}{
+		{"single DDL", []string{"ALTER TABLE t ADD COLUMN a int"}, true},
+		{"updates before DDL", []string{"UPDATE t SET a = 0", "alter table t modify a double"}, true},
+		{"two DDL", []string{"ALTER TABLE t ADD COLUMN a int", "ALTER TABLE t ADD COLUMN b int"}, false},
+		{"DDL before update", []string{"CREATE TABLE t (a int)", "INSERT INTO t VALUES (1)"}, false},
+		{"only updates", []string{"UPDATE t SET a = 0", "DELETE FROM t"}, true},
+	}
+	for _, tt := range tests {
+		m := Migration{Version: 1, Statements: tt.statements}
+		if err := (Dialect{ImplicitCommit: true}).validate(m); (err == nil) != tt.ok {
+			t.Errorf("%s: validate() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if err := (Dialect{}).validate(m); err != nil {
+			t.Errorf("%s: transactional dialect: %s", tt.name, err)
+		}
+	}
+}
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func version(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var v sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM ` + versionTable).Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	return int(v.Int64)
+}
+
+func TestRun(t *testing.T) {
+	db := openSQLite(t)
+	migrations := []Migration{
+		{Version: 2, Statements: []string{`ALTER TABLE speedtest_users ADD COLUMN b TEXT`}},
+		{Version: 1, Statements: []string{`CREATE TABLE speedtest_users (a TEXT)`}},
+	}
+
+	if err := Run(db, Dialect{}, migrations); err != nil {
+		t.Fatal(err)
+	}
+	if v := version(t, db); v != 2 {
+		t.Errorf("version = %d, want 2", v)
+	}
+	// already applied migrations are skipped
+	if err := Run(db, Dialect{}, migrations); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failing migration is rolled back and keeps the previous version
+	failing := append(migrations, Migration{Version: 3, Statements: []string{
+		`ALTER TABLE speedtest_users ADD COLUMN c TEXT`,
+		`ALTER TABLE missing ADD COLUMN d TEXT`,
+	}})
+	if err := Run(db, Dialect{}, failing); err == nil {
+		t.Fatal("expected migration 3 to fail")
+	}
+	if v := version(t, db); v != 2 {
+		t.Errorf("version = %d after failed migration, want 2", v)
+	}
+	if _, err := db.Exec(`SELECT c FROM speedtest_users`); err == nil {
+		t.Error("column c of the failed migration exists")
+	}
+}
+
+func TestRunLegacyDatabase(t *testing.T) {
+	db := openSQLite(t)
+	if _, err := db.Exec(`CREATE TABLE speedtest_users (a TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+
+	migrations := []Migration{
+		{Version: 1, Statements: []string{`CREATE TABLE speedtest_users (a TEXT)`}},
+		{Version: 2, Statements: []string{`ALTER TABLE speedtest_users ADD COLUMN b TEXT`}},
+	}
+	if err := Run(db, Dialect{}, migrations); err != nil {
+		t.Fatal(err)
+	}
+	if v := version(t, db); v != 2 {
+		t.Errorf("version = %d, want 2", v)
+	}
+}
+
+func TestRunRejectsInvalidMigrations(t *testing.T) {
+	db := openSQLite(t)
+	err := Run(db, Dialect{ImplicitCommit: true}, []Migration{
+		{Version: 1, Statements: []string{`CREATE TABLE a (x TEXT)`, `CREATE TABLE b (x TEXT)`}},
+	})
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	if _, err := db.Exec(`SELECT 1 FROM a`); err == nil {
+		t.Error("invalid migration was applied")
+	}
+}
+
+func TestRunAlreadyApplied(t *testing.T) {
+	db := openSQLite(t)
+	d := Dialect{
+		ImplicitCommit: true,
+		AlreadyApplied: func(err error) bool { return strings.Contains(err.Error(), "duplicate column") },
+	}
+	migrations := []Migration{
+		{Version: 1, Statements: []string{`CREATE TABLE speedtest_users (a TEXT)`}},
+		{Version: 2, Statements: []string{`ALTER TABLE speedtest_users ADD COLUMN b TEXT`}},
+	}
+	if err := Run(db, d, migrations[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	// a previous run added the column but stopped before recording version 2
+	if _, err := db.Exec(`ALTER TABLE speedtest_users ADD COLUMN b TEXT`); err != nil {
+		t.Fatal(err)
+	}
+	if err := Run(db, d, migrations); err != nil {
+		t.Fatal(err)
+	}
+	if v := version(t, db); v != 2 {
+		t.Errorf("version = %d, want 2", v)
+	}
+
+	// other errors still fail the migration
+	failing := append(migrations, Migration{Version: 3, Statements: []string{`ALTER TABLE missing ADD COLUMN c TEXT`}})
+	if err := Run(db, d, failing); err == nil {
+		t.Fatal("expected migration 3 to fail")
+	}
+}
+
+func TestRunSingleTransaction(t *testing.T) {
+	db := openSQLite(t)
+	d := Dialect{Begin: `BEGIN IMMEDIATE`}
+	migrations := []Migration{
+		{Version: 1, Statements: []string{`CREATE TABLE speedtest_users (a TEXT)`}},
+		{Version: 2, Statements: []string{`ALTER TABLE speedtest_users ADD COLUMN b TEXT`}},
+	}
+
+	// a failing migration rolls back the whole run
+	failing := append(migrations, Migration{Version: 3, Statements: []string{`ALTER TABLE missing ADD COLUMN c TEXT`}})
+	if err := Run(db, d, failing); err == nil {
+		t.Fatal("expected migration 3 to fail")
+	}
+	if _, err := db.Exec(`SELECT 1 FROM speedtest_users`); err == nil {
+		t.Error("migration 1 of the failed run was kept")
+	}
+
+	if err := Run(db, d, migrations); err != nil {
+		t.Fatal(err)
+	}
+	if v := version(t, db); v != 2 {
+		t.Errorf("version = %d, want 2", v)
+	}
+}
+
+func TestRunConcurrentInstances(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "speedtest.db")
+	migrations := []Migration{
+		{Version: 1, Statements: []string{
+			`CREATE TABLE speedtest_users (a TEXT)`,
+			// keep the write lock long enough for the other instances to read the version
+			`INSERT INTO speedtest_users WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n WHERE x < 50000) SELECT x FROM n`,
+		}},
+		{Version: 2, Statements: []string{`ALTER TABLE speedtest_users ADD COLUMN b TEXT`}},
+	}
+
+	const instances = 8
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, instances)
+	for i := 0; i < instances; i++ {
+		db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.SetMaxOpenConns(1)
+		t.Cleanup(func() { _ = db.Close() })
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs <- Run(db, Dialect{Begin: `BEGIN IMMEDIATE`}, migrations)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ` + versionTable).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("%d versions recorded, want 2", count)
+	}
+}
@@ -0,0 +1,106 @@
+package mysql
+
+import (
+	"errors"
+
+	"github.com/librespeed/speedtest/database/migration"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// errDuplicateColumn is returned by ALTER TABLE ... ADD COLUMN for columns that exist already
+const errDuplicateColumn = 1060
+
+var migrationDialect = migration.Dialect{
+	Lock:           "SELECT GET_LOCK('speedtest_schema_migration', 600)",
+	Unlock:         "SELECT RELEASE_LOCK('speedtest_schema_migration')",
+	ImplicitCommit: true,
+	AlreadyApplied: isDuplicateColumn,
+}
+
+// isDuplicateColumn reports whether an ADD COLUMN step was committed by an earlier run that
+// stopped before recording the schema version. ALTER TABLE with several ADD COLUMN clauses
+// is applied as a whole, so one existing column means all of them were added.
+func isDuplicateColumn(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateColumn
+}
+
+var migrations = []migration.Migration{
+	{
+		Version:     1,
+		Description: "create speedtest_users table",
+		Statements: []string{
+			"CREATE TABLE IF NOT EXISTS `speedtest_users` (" +
+				"`id` int(11) NOT NULL AUTO_INCREMENT," +
+				"`timestamp` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP," +
+				"`ip` text NOT NULL," +
+				"`ispinfo` text," +
+				"`extra` text," +
+				"`ua` text NOT NULL," +
+				"`lang` text NOT NULL," +
+				"`dl` text," +
+				"`ul` text," +
+				"`ping` text," +
+				"`jitter` text," +
+				"`log` longtext," +
+				"`uuid` text," +
+				"PRIMARY KEY (`id`)" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+		},
+	},
+	{
+		Version:     2,
+		Description: "convert dl, ul, ping and jitter to numeric columns",
+		Statements: []string{
+			"UPDATE `speedtest_users` SET `dl` = '0' WHERE `dl` IS NULL OR TRIM(`dl`) NOT REGEXP '^[0-9]+([.][0-9]+)?$'",
+			"UPDATE `speedtest_users` SET `ul` = '0' WHERE `ul` IS NULL OR TRIM(`ul`) NOT REGEXP '^[0-9]+([.][0-9]+)?$'",
+			"UPDATE `speedtest_users` SET `ping` = '0' WHERE `ping` IS NULL OR TRIM(`ping`) NOT REGEXP '^[0-9]+([.][0-9]+)?$'",
+			"UPDATE `speedtest_users` SET `jitter` = '0' WHERE `jitter` IS NULL OR TRIM(`jitter`) NOT REGEXP '^[0-9]+([.][0-9]+)?$'",
+			"ALTER TABLE `speedtest_users` " +
+				"MODIFY `dl` double NOT NULL DEFAULT 0," +
+				"MODIFY `ul` double NOT NULL DEFAULT 0," +
+				"MODIFY `ping` double NOT NULL DEFAULT 0," +
+				"MODIFY `jitter` double NOT NULL DEFAULT 0",
+		},
+	},
+	{
+		Version:     3,
+		Description: "add server side measurements and plausibility flags",
+		Statements: []string{
+			"ALTER TABLE `speedtest_users` " +
+				"ADD COLUMN `server_dl` double NOT NULL DEFAULT 0," +
+				"ADD COLUMN `server_ul` double NOT NULL DEFAULT 0," +
+				"ADD COLUMN `flags` text",
+		},
+	},
+	{
+		Version:     4,
+		Description: "add site of internal networks",
+		Statements: []string{
+			"ALTER TABLE `speedtest_users` ADD COLUMN `site` text",
+		},
+	},
+	{
+		Version:     5,
+		Description: "add server side latency measurements",
+		Statements: []string{
+			"ALTER TABLE `speedtest_users` " +
+				"ADD COLUMN `server_ping` double NOT NULL DEFAULT 0," +
+				"ADD COLUMN `server_jitter` double NOT NULL DEFAULT 0," +
+				"ADD COLUMN `packet_loss` double NOT NULL DEFAULT 0",
+		},
+	},
+	{
+		Version:     6,
+		Description: "add latency under load",
+		Statements: []string{
+			"ALTER TABLE `speedtest_users` " +
+				"ADD COLUMN `loaded_ping_dl` double NOT NULL DEFAULT 0," +
+				"ADD COLUMN `loaded_ping_ul` double NOT NULL DEFAULT 0," +
+				"ADD COLUMN `loaded_packet_loss` double NOT NULL DEFAULT 0," +
+				"ADD COLUMN `bufferbloat` text," +
+				"ADD COLUMN `loaded_latency` text",
+		},
+	},
+}
@@ -1,10 +1,13 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
+	"github.com/librespeed/speedtest/database/migration"
 	"github.com/librespeed/speedtest/database/schema"
+	"github.com/librespeed/speedtest/database/sqlquery"
 
 	_ "github.com/go-sql-driver/mysql"
 	log "github.com/sirupsen/logrus"
@@ -24,43 +27,40 @@ func Open(hostname, username, password, database string) *MySQL {
 	if err != nil {
 		log.Fatalf("Cannot open MySQL database: %s", err)
 	}
+	if err := migration.Run(conn, migrationDialect, migrations); err != nil {
+		log.Fatalf("Cannot migrate MySQL database schema: %s", err)
+	}
 	return &MySQL{db: conn}
 }
 
 func (p *MySQL) Insert(data *schema.TelemetryData) error {
-	stmt := `INSERT INTO speedtest_users (ip, ispinfo, extra, ua, lang, dl, ul, ping, jitter, log, uuid) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
-	_, err := p.db.Exec(stmt, data.IPAddress, data.ISPInfo, data.Extra, data.UserAgent, data.Language, data.Download, data.Upload, data.Ping, data.Jitter, data.Log, data.UUID)
+	stmt := `INSERT INTO speedtest_users (ip, ispinfo, extra, ua, lang, dl, ul, ping, jitter, log, uuid, server_dl, server_ul, flags, site, server_ping, server_jitter, packet_loss, loaded_ping_dl, loaded_ping_ul, loaded_packet_loss, bufferbloat, loaded_latency) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err := p.db.Exec(stmt, data.IPAddress, data.ISPInfo, data.Extra, data.UserAgent, data.Language, data.Download, data.Upload, data.Ping, data.Jitter, data.Log, data.UUID, data.ServerDownload, data.ServerUpload, data.Flags, data.Site, data.ServerPing, data.ServerJitter, data.PacketLoss,
+		data.LoadedPingDownload, data.LoadedPingUpload, data.LoadedPacketLoss, data.Bufferbloat, data.LoadedLatency)
 	return err
 }
 
 func (p *MySQL) FetchByUUID(uuid string) (*schema.TelemetryData, error) {
-	var record schema.TelemetryData
-	row := p.db.QueryRow(`SELECT * FROM speedtest_users WHERE uuid = ?`, uuid)
-	if row != nil {
-		var id string
-		if err := row.Scan(&id, &record.Timestamp, &record.IPAddress, &record.ISPInfo, &record.Extra, &record.UserAgent, &record.Language, &record.Download, &record.Upload, &record.Ping, &record.Jitter, &record.Log, &record.UUID); err != nil {
-			return nil, err
-		}
+	row := p.db.QueryRow(`SELECT `+sqlquery.MySQL.Columns()+` FROM speedtest_users WHERE uuid = ?`, uuid)
+	record, err := sqlquery.Scan(row)
+	if err != nil {
+		return nil, err
 	}
 	return &record, nil
 }
 
-func (p *MySQL) FetchLast100() ([]schema.TelemetryData, error) {
-	var records []schema.TelemetryData
-	rows, err := p.db.Query(`SELECT * FROM speedtest_users ORDER BY "timestamp" DESC LIMIT 100;`)
-	if err != nil {
-		return nil, err
-	}
-	if rows != nil {
-		var id string
+func (p *MySQL) Query(q schema.Query) (*schema.Page, error) {
+	return sqlquery.MySQL.Query(p.db, q)
+}
 
-		for rows.Next() {
-			var record schema.TelemetryData
-			if err := rows.Scan(&id, &record.Timestamp, &record.IPAddress, &record.ISPInfo, &record.Extra, &record.UserAgent, &record.Language, &record.Download, &record.Upload, &record.Ping, &record.Jitter, &record.Log, &record.UUID); err != nil {
-				return nil, err
-			}
-			records = append(records, record)
-		}
-	}
-	return records, nil
+func (p *MySQL) Aggregate(q schema.AggregateQuery) ([]schema.Aggregate, error) {
+	return sqlquery.MySQL.Aggregate(p.db, q)
+}
+
+func (p *MySQL) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+func (p *MySQL) Close() error {
+	return p.db.Close()
 }
@@ -1,6 +1,8 @@
 package none
 
 import (
+	"context"
+
 	"github.com/librespeed/speedtest/database/schema"
 )
 
@@ -18,6 +20,18 @@ func (n *None) FetchByUUID(_ string) (*schema.TelemetryData, error) {
 	return &schema.TelemetryData{}, nil
 }
 
-func (n *None) FetchLast100() ([]schema.TelemetryData, error) {
-	return []schema.TelemetryData{}, nil
+func (n *None) Query(_ schema.Query) (*schema.Page, error) {
+	return &schema.Page{}, nil
+}
+
+func (n *None) Aggregate(_ schema.AggregateQuery) ([]schema.Aggregate, error) {
+	return []schema.Aggregate{}, nil
+}
+
+func (n *None) Ping(_ context.Context) error {
+	return nil
+}
+
+func (n *None) Close() error {
+	return nil
 }
@@ -0,0 +1,93 @@
+package postgresql
+
+import (
+	"github.com/librespeed/speedtest/database/migration"
+)
+
+// the advisory lock key is arbitrary, but has to be the same for all instances
+var migrationDialect = migration.Dialect{
+	Lock:   "SELECT 1 FROM pg_advisory_lock(7365686)",
+	Unlock: "SELECT pg_advisory_unlock(7365686)",
+}
+
+var migrations = []migration.Migration{
+	{
+		Version:     1,
+		Description: "create speedtest_users table",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS speedtest_users (
+				id serial PRIMARY KEY,
+				"timestamp" timestamp without time zone DEFAULT now() NOT NULL,
+				ip text NOT NULL,
+				ispinfo text,
+				extra text,
+				ua text NOT NULL,
+				lang text NOT NULL,
+				dl text,
+				ul text,
+				ping text,
+				jitter text,
+				log text,
+				uuid text
+			)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "convert dl, ul, ping and jitter to numeric columns",
+		Statements: []string{
+			`ALTER TABLE speedtest_users
+				ALTER COLUMN dl TYPE double precision USING (CASE WHEN trim(dl) ~ '^[0-9]+([.][0-9]+)?$' THEN trim(dl)::double precision ELSE 0 END),
+				ALTER COLUMN dl SET DEFAULT 0,
+				ALTER COLUMN dl SET NOT NULL,
+				ALTER COLUMN ul TYPE double precision USING (CASE WHEN trim(ul) ~ '^[0-9]+([.][0-9]+)?$' THEN trim(ul)::double precision ELSE 0 END),
+				ALTER COLUMN ul SET DEFAULT 0,
+				ALTER COLUMN ul SET NOT NULL,
+				ALTER COLUMN ping TYPE double precision USING (CASE WHEN trim(ping) ~ '^[0-9]+([.][0-9]+)?$' THEN trim(ping)::double precision ELSE 0 END),
+				ALTER COLUMN ping SET DEFAULT 0,
+				ALTER COLUMN ping SET NOT NULL,
+				ALTER COLUMN jitter TYPE double precision USING (CASE WHEN trim(jitter) ~ '^[0-9]+([.][0-9]+)?$' THEN trim(jitter)::double precision ELSE 0 END),
+				ALTER COLUMN jitter SET DEFAULT 0,
+				ALTER COLUMN jitter SET NOT NULL`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "add server side measurements and plausibility flags",
+		Statements: []string{
+			`ALTER TABLE speedtest_users
+				ADD COLUMN server_dl double precision NOT NULL DEFAULT 0,
+				ADD COLUMN server_ul double precision NOT NULL DEFAULT 0,
+				ADD COLUMN flags text NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "add site of internal networks",
+		Statements: []string{
+			`ALTER TABLE speedtest_users ADD COLUMN site text NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "add server side latency measurements",
+		Statements: []string{
+			`ALTER TABLE speedtest_users
+				ADD COLUMN server_ping double precision NOT NULL DEFAULT 0,
+				ADD COLUMN server_jitter double precision NOT NULL DEFAULT 0,
+				ADD COLUMN packet_loss double precision NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		Version:     6,
+		Description: "add latency under load",
+		Statements: []string{
+			`ALTER TABLE speedtest_users
+				ADD COLUMN loaded_ping_dl double precision NOT NULL DEFAULT 0,
+				ADD COLUMN loaded_ping_ul double precision NOT NULL DEFAULT 0,
+				ADD COLUMN loaded_packet_loss double precision NOT NULL DEFAULT 0,
+				ADD COLUMN bufferbloat text NOT NULL DEFAULT '',
+				ADD COLUMN loaded_latency text NOT NULL DEFAULT ''`,
+		},
+	},
+}
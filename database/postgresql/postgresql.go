@@ -1,10 +1,13 @@
 package postgresql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
+	"github.com/librespeed/speedtest/database/migration"
 	"github.com/librespeed/speedtest/database/schema"
+	"github.com/librespeed/speedtest/database/sqlquery"
 
 	_ "github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
@@ -24,43 +27,40 @@ func Open(hostname, username, password, database string) *PostgreSQL {
 	if err != nil {
 		log.Fatalf("Cannot open PostgreSQL database: %s", err)
 	}
+	if err := migration.Run(conn, migrationDialect, migrations); err != nil {
+		log.Fatalf("Cannot migrate PostgreSQL database schema: %s", err)
+	}
 	return &PostgreSQL{db: conn}
 }
 
 func (p *PostgreSQL) Insert(data *schema.TelemetryData) error {
-	stmt := `INSERT INTO speedtest_users (ip, ispinfo, extra, ua, lang, dl, ul, ping, jitter, log, uuid) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id;`
-	_, err := p.db.Exec(stmt, data.IPAddress, data.ISPInfo, data.Extra, data.UserAgent, data.Language, data.Download, data.Upload, data.Ping, data.Jitter, data.Log, data.UUID)
+	stmt := `INSERT INTO speedtest_users (ip, ispinfo, extra, ua, lang, dl, ul, ping, jitter, log, uuid, server_dl, server_ul, flags, site, server_ping, server_jitter, packet_loss, loaded_ping_dl, loaded_ping_ul, loaded_packet_loss, bufferbloat, loaded_latency) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23) RETURNING id;`
+	_, err := p.db.Exec(stmt, data.IPAddress, data.ISPInfo, data.Extra, data.UserAgent, data.Language, data.Download, data.Upload, data.Ping, data.Jitter, data.Log, data.UUID, data.ServerDownload, data.ServerUpload, data.Flags, data.Site, data.ServerPing, data.ServerJitter, data.PacketLoss,
+		data.LoadedPingDownload, data.LoadedPingUpload, data.LoadedPacketLoss, data.Bufferbloat, data.LoadedLatency)
 	return err
 }
 
 func (p *PostgreSQL) FetchByUUID(uuid string) (*schema.TelemetryData, error) {
-	var record schema.TelemetryData
-	row := p.db.QueryRow(`SELECT * FROM speedtest_users WHERE uuid = $1`, uuid)
-	if row != nil {
-		var id string
-		if err := row.Scan(&id, &record.Timestamp, &record.IPAddress, &record.ISPInfo, &record.Extra, &record.UserAgent, &record.Language, &record.Download, &record.Upload, &record.Ping, &record.Jitter, &record.Log, &record.UUID); err != nil {
-			return nil, err
-		}
+	row := p.db.QueryRow(`SELECT `+sqlquery.PostgreSQL.Columns()+` FROM speedtest_users WHERE uuid = $1`, uuid)
+	record, err := sqlquery.Scan(row)
+	if err != nil {
+		return nil, err
 	}
 	return &record, nil
 }
 
-func (p *PostgreSQL) FetchLast100() ([]schema.TelemetryData, error) {
-	var records []schema.TelemetryData
-	rows, err := p.db.Query(`SELECT * FROM speedtest_users ORDER BY "timestamp" DESC LIMIT 100;`)
-	if err != nil {
-		return nil, err
-	}
-	if rows != nil {
-		var id string
+func (p *PostgreSQL) Query(q schema.Query) (*schema.Page, error) {
+	return sqlquery.PostgreSQL.Query(p.db, q)
+}
 
-		for rows.Next() {
-			var record schema.TelemetryData
-			if err := rows.Scan(&id, &record.Timestamp, &record.IPAddress, &record.ISPInfo, &record.Extra, &record.UserAgent, &record.Language, &record.Download, &record.Upload, &record.Ping, &record.Jitter, &record.Log, &record.UUID); err != nil {
-				return nil, err
-			}
-			records = append(records, record)
-		}
-	}
-	return records, nil
+func (p *PostgreSQL) Aggregate(q schema.AggregateQuery) ([]schema.Aggregate, error) {
+	return sqlquery.PostgreSQL.Aggregate(p.db, q)
+}
+
+func (p *PostgreSQL) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+func (p *PostgreSQL) Close() error {
+	return p.db.Close()
 }
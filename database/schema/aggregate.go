@@ -0,0 +1,250 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Period string
+
+const (
+	Hour Period = "hour"
+	Day  Period = "day"
+	Week Period = "week"
+)
+
+type GroupBy string
+
+const (
+	GroupNone    GroupBy = ""
+	GroupISP     GroupBy = "isp"
+	GroupCountry GroupBy = "country"
+)
+
+const (
+	unknownGroup = "Unknown"
+)
+
+var (
+	asnPrefixRegex = regexp.MustCompile(`AS\d+\s`)
+)
+
+// AggregateQuery groups the records selected by the embedded Query per period and,
+// optionally, ISP or country. Order, Cursor and Limit are ignored.
+type AggregateQuery struct {
+	Query
+	Period  Period
+	GroupBy GroupBy
+}
+
+// Summary describes the distribution of one measurement. Failed or skipped measurements
+// (stored as 0) are left out, so Count can be lower than the number of tests.
+type Summary struct {
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	P10    float64 `json:"p10"`
+	P90    float64 `json:"p90"`
+}
+
+type Aggregate struct {
+	Period   time.Time `json:"period"`
+	Group    string    `json:"group,omitempty"`
+	Count    int       `json:"count"`
+	Download Summary   `json:"download"`
+	Upload   Summary   `json:"upload"`
+	Ping     Summary   `json:"ping"`
+	Jitter   Summary   `json:"jitter"`
+}
+
+// Validate checks Period and GroupBy, defaulting an empty Period to Day
+func (q *AggregateQuery) Validate() error {
+	switch q.Period {
+	case "":
+		q.Period = Day
+	case Hour, Day, Week:
+	default:
+		return fmt.Errorf("unsupported period: %s", q.Period)
+	}
+
+	switch q.GroupBy {
+	case GroupNone, GroupISP, GroupCountry:
+	default:
+		return fmt.Errorf("unsupported grouping: %s", q.GroupBy)
+	}
+	return nil
+}
+
+type aggregateKey struct {
+	period time.Time
+	group  string
+}
+
+type aggregateValues struct {
+	count                          int
+	download, upload, ping, jitter []float64
+}
+
+// Aggregator computes aggregates from records fed to it one by one, for backends that
+// cannot compute percentiles natively
+type Aggregator struct {
+	query  AggregateQuery
+	groups map[aggregateKey]*aggregateValues
+}
+
+func NewAggregator(q AggregateQuery) *Aggregator {
+	return &Aggregator{
+		query:  q,
+		groups: make(map[aggregateKey]*aggregateValues),
+	}
+}
+
+// Add counts a record. Filtering is left to the caller.
+func (a *Aggregator) Add(record *TelemetryData) {
+	key := aggregateKey{period: truncate(record.Timestamp, a.query.Period)}
+	switch a.query.GroupBy {
+	case GroupISP:
+		key.group = ispName(record.ISPInfo)
+	case GroupCountry:
+		key.group = countryName(record.ISPInfo)
+	}
+
+	values, ok := a.groups[key]
+	if !ok {
+		values = &aggregateValues{}
+		a.groups[key] = values
+	}
+
+	values.count++
+	appendPositive(&values.download, record.Download)
+	appendPositive(&values.upload, record.Upload)
+	appendPositive(&values.ping, record.Ping)
+	appendPositive(&values.jitter, record.Jitter)
+}
+
+// Result returns the aggregates ordered by period and group
+func (a *Aggregator) Result() []Aggregate {
+	result := make([]Aggregate, 0, len(a.groups))
+	for key, values := range a.groups {
+		result = append(result, Aggregate{
+			Period:   key.period,
+			Group:    key.group,
+			Count:    values.count,
+			Download: summarize(values.download),
+			Upload:   summarize(values.upload),
+			Ping:     summarize(values.ping),
+			Jitter:   summarize(values.jitter),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Period.Equal(result[j].Period) {
+			return result[i].Period.Before(result[j].Period)
+		}
+		return result[i].Group < result[j].Group
+	})
+	return result
+}
+
+func appendPositive(values *[]float64, v float64) {
+	if v > 0 {
+		*values = append(*values, v)
+	}
+}
+
+func summarize(values []float64) Summary {
+	if len(values) == 0 {
+		return Summary{}
+	}
+
+	sort.Float64s(values)
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+
+	return Summary{
+		Count:  len(values),
+		Mean:   sum / float64(len(values)),
+		Median: percentile(values, 0.5),
+		P10:    percentile(values, 0.1),
+		P90:    percentile(values, 0.9),
+	}
+}
+
+// percentile interpolates linearly between the closest ranks of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := p * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+func truncate(t time.Time, period Period) time.Time {
+	t = t.UTC()
+	switch period {
+	case Hour:
+		return t.Truncate(time.Hour)
+	case Week:
+		// weeks start on Monday, as in ISO 8601
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// ispInfo is the ispinfo object sent by the JavaScript client. rawIspInfo is an empty
+// string when the client did not request ISP info, so it is decoded separately.
+type ispInfo struct {
+	ProcessedString string          `json:"processedString"`
+	RawISPInfo      json.RawMessage `json:"rawIspInfo"`
+}
+
+type rawISPInfo struct {
+	Country      string `json:"country"`
+	Organization string `json:"org"`
+}
+
+func parseISPInfo(s string) (ispInfo, rawISPInfo) {
+	var info ispInfo
+	var raw rawISPInfo
+	if err := json.Unmarshal([]byte(s), &info); err != nil {
+		return info, raw
+	}
+	_ = json.Unmarshal(info.RawISPInfo, &raw)
+	return info, raw
+}
+
+func ispName(s string) string {
+	info, raw := parseISPInfo(s)
+
+	isp := strings.TrimSpace(asnPrefixRegex.ReplaceAllString(raw.Organization, ""))
+	if isp == "" {
+		// fall back to the "IP - ISP, country (distance)" format of getIP
+		parts := strings.SplitN(info.ProcessedString, " - ", 2)
+		if len(parts) == 2 {
+			isp = strings.TrimSpace(strings.SplitN(parts[1], ",", 2)[0])
+		}
+	}
+
+	if isp == "" || isp == "Unknown ISP" {
+		return unknownGroup
+	}
+	return isp
+}
+
+func countryName(s string) string {
+	_, raw := parseISPInfo(s)
+	if raw.Country == "" {
+		return unknownGroup
+	}
+	return raw.Country
+}
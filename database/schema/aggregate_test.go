@@ -0,0 +1,167 @@
+package schema
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAggregateQueryValidate(t *testing.T) {
+	tests := []struct {
+		period  Period
+		group   GroupBy
+		want    Period
+		invalid bool
+	}{
+		{"", GroupNone, Day, false},
+		{Hour, GroupISP, Hour, false},
+		{Week, GroupCountry, Week, false},
+		{"month", GroupNone, "", true},
+		{Day, "city", "", true},
+	}
+	for _, tt := range tests {
+		q := AggregateQuery{Period: tt.period, GroupBy: tt.group}
+		err := q.Validate()
+		if (err != nil) != tt.invalid {
+			t.Errorf("Validate() of %q/%q = %v", tt.period, tt.group, err)
+		}
+		if err == nil && q.Period != tt.want {
+			t.Errorf("period %q became %q, want %q", tt.period, q.Period, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	ts := time.Date(2024, 3, 10, 23, 45, 0, 0, time.UTC) // a Sunday
+	tests := []struct {
+		t      time.Time
+		period Period
+		want   time.Time
+	}{
+		{ts, Hour, time.Date(2024, 3, 10, 23, 0, 0, 0, time.UTC)},
+		{ts, Day, time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)},
+		{ts, Week, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), Week, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},
+		// periods are in UTC
+		{time.Date(2024, 3, 11, 0, 30, 0, 0, time.FixedZone("CET", 3600)), Week, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.t, tt.period); !got.Equal(tt.want) {
+			t.Errorf("truncate(%v, %s) = %v, want %v", tt.t, tt.period, got, tt.want)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   Summary
+	}{
+		{nil, Summary{}},
+		{[]float64{5}, Summary{Count: 1, Mean: 5, Median: 5, P10: 5, P90: 5}},
+		{[]float64{200, 100}, Summary{Count: 2, Mean: 150, Median: 150, P10: 110, P90: 190}},
+		{[]float64{3, 1, 2}, Summary{Count: 3, Mean: 2, Median: 2, P10: 1.2, P90: 2.8}},
+	}
+	for _, tt := range tests {
+		got := summarize(append([]float64(nil), tt.values...))
+		if !summaryEqual(got, tt.want) {
+			t.Errorf("summarize(%v) = %+v, want %+v", tt.values, got, tt.want)
+		}
+	}
+}
+
+func summaryEqual(a, b Summary) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) < 1e-9 }
+	return a.Count == b.Count && near(a.Mean, b.Mean) && near(a.Median, b.Median) && near(a.P10, b.P10) && near(a.P90, b.P90)
+}
+
+func aggregateRecords() []TelemetryData {
+	return []TelemetryData{
+		{
+			Timestamp: time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC),
+			ISPInfo:   `{"processedString":"192.0.2.1 - AS64496 Example ISP, DE","rawIspInfo":{"org":"AS64496 Example ISP","country":"DE"}}`,
+			Download:  100, Upload: 10, Ping: 20, Jitter: 2,
+		},
+		{
+			Timestamp: time.Date(2024, 3, 4, 11, 30, 0, 0, time.UTC),
+			ISPInfo:   `{"processedString":"192.0.2.2 - AS64496 Example ISP, DE","rawIspInfo":{"org":"AS64496 Example ISP","country":"DE"}}`,
+			Download:  200, Ping: 30, Jitter: 4,
+		},
+		{
+			Timestamp: time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC),
+			ISPInfo:   `{"processedString":"198.51.100.1 - Other ISP, FR (3 km)","rawIspInfo":""}`,
+			Download:  50, Upload: 5, Ping: 10, Jitter: 1,
+		},
+		{
+			Timestamp: time.Date(2024, 3, 10, 23, 0, 0, 0, time.UTC),
+			Download:  80, Upload: 8, Ping: 15, Jitter: 3,
+		},
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	type group struct {
+		period   time.Time
+		group    string
+		count    int
+		download Summary
+	}
+	tests := []struct {
+		name string
+		q    AggregateQuery
+		want []group
+	}{
+		{"per day", AggregateQuery{Period: Day}, []group{
+			{day(4), "", 2, Summary{Count: 2, Mean: 150, Median: 150, P10: 110, P90: 190}},
+			{day(5), "", 1, Summary{Count: 1, Mean: 50, Median: 50, P10: 50, P90: 50}},
+			{day(10), "", 1, Summary{Count: 1, Mean: 80, Median: 80, P10: 80, P90: 80}},
+		}},
+		{"per week and ISP", AggregateQuery{Period: Week, GroupBy: GroupISP}, []group{
+			{day(4), "Example ISP", 2, Summary{Count: 2, Mean: 150, Median: 150, P10: 110, P90: 190}},
+			{day(4), "Other ISP", 1, Summary{Count: 1, Mean: 50, Median: 50, P10: 50, P90: 50}},
+			{day(4), unknownGroup, 1, Summary{Count: 1, Mean: 80, Median: 80, P10: 80, P90: 80}},
+		}},
+		{"per week and country", AggregateQuery{Period: Week, GroupBy: GroupCountry}, []group{
+			{day(4), "DE", 2, Summary{Count: 2, Mean: 150, Median: 150, P10: 110, P90: 190}},
+			{day(4), unknownGroup, 2, Summary{Count: 2, Mean: 65, Median: 65, P10: 53, P90: 77}},
+		}},
+	}
+	for _, tt := range tests {
+		a := NewAggregator(tt.q)
+		records := aggregateRecords()
+		for i := range records {
+			a.Add(&records[i])
+		}
+		result := a.Result()
+
+		if len(result) != len(tt.want) {
+			t.Fatalf("%s: got %d aggregates, want %d: %+v", tt.name, len(result), len(tt.want), result)
+		}
+		for i, want := range tt.want {
+			got := result[i]
+			if !got.Period.Equal(want.period) || got.Group != want.group || got.Count != want.count {
+				t.Errorf("%s: aggregate %d is %v/%q with %d tests, want %v/%q with %d", tt.name, i,
+					got.Period, got.Group, got.Count, want.period, want.group, want.count)
+			}
+			if !summaryEqual(got.Download, want.download) {
+				t.Errorf("%s: aggregate %d download = %+v, want %+v", tt.name, i, got.Download, want.download)
+			}
+		}
+	}
+}
+
+func TestAggregatorSkipsFailedMeasurements(t *testing.T) {
+	a := NewAggregator(AggregateQuery{Period: Day})
+	records := aggregateRecords()[:2]
+	for i := range records {
+		a.Add(&records[i])
+	}
+	result := a.Result()
+	if len(result) != 1 {
+		t.Fatalf("got %d aggregates, want 1", len(result))
+	}
+	if result[0].Count != 2 || result[0].Upload.Count != 1 || result[0].Upload.Mean != 10 {
+		t.Errorf("aggregate = %+v, want 2 tests with 1 upload of 10", result[0])
+	}
+}
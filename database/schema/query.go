@@ -0,0 +1,159 @@
+package schema
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+type SortOrder int
+
+const (
+	NewestFirst SortOrder = iota
+	OldestFirst
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Query selects telemetry records. Zero values mean no filtering on that field.
+type Query struct {
+	// From is inclusive, To is exclusive
+	From time.Time
+	To   time.Time
+
+	// IPAddress must match exactly, ISP (matched against ISPInfo) and UserAgent are
+	// case-insensitive substrings
+	IPAddress string
+	ISP       string
+	UserAgent string
+
+	Order SortOrder
+
+	// Cursor is the NextCursor of the previous page, empty for the first page
+	Cursor string
+	Limit  int
+}
+
+type Page struct {
+	Records []TelemetryData
+	// NextCursor is empty when there are no more records
+	NextCursor string
+}
+
+// PageSize returns the effective Limit, clamped to MaxPageSize
+func (q Query) PageSize() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultPageSize
+	case q.Limit > MaxPageSize:
+		return MaxPageSize
+	default:
+		return q.Limit
+	}
+}
+
+// Matches reports whether a record passes the time range and field filters, ignoring the cursor
+func (q Query) Matches(record *TelemetryData) bool {
+	if !q.From.IsZero() && record.Timestamp.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !record.Timestamp.Before(q.To) {
+		return false
+	}
+	if q.IPAddress != "" && record.IPAddress != q.IPAddress {
+		return false
+	}
+	if q.ISP != "" && !containsFold(record.ISPInfo, q.ISP) {
+		return false
+	}
+	if q.UserAgent != "" && !containsFold(record.UserAgent, q.UserAgent) {
+		return false
+	}
+	return true
+}
+
+// Less reports whether a comes before b in the query's sort order
+func (q Query) Less(a, b *TelemetryData) bool {
+	before := a.Timestamp.Before(b.Timestamp) || (a.Timestamp.Equal(b.Timestamp) && a.UUID < b.UUID)
+	if q.Order == OldestFirst {
+		return before
+	}
+	return !before && !(a.Timestamp.Equal(b.Timestamp) && a.UUID == b.UUID)
+}
+
+// Apply filters, sorts and paginates an in-memory list of records
+func (q Query) Apply(records []TelemetryData) (*Page, error) {
+	var cursor *TelemetryData
+	if q.Cursor != "" {
+		ts, uuid, err := DecodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		cursor = &TelemetryData{Timestamp: ts, UUID: uuid}
+	}
+
+	var matched []TelemetryData
+	for i := range records {
+		if !q.Matches(&records[i]) {
+			continue
+		}
+		if cursor != nil && !q.Less(cursor, &records[i]) {
+			continue
+		}
+		matched = append(matched, records[i])
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return q.Less(&matched[i], &matched[j])
+	})
+
+	return q.NewPage(matched), nil
+}
+
+// NewPage builds a page from records fetched in sort order. Backends should fetch one
+// record more than PageSize so that NewPage can tell whether there is a next page.
+func (q Query) NewPage(records []TelemetryData) *Page {
+	page := &Page{Records: records}
+	if len(records) > q.PageSize() {
+		page.Records = records[:q.PageSize()]
+		page.NextCursor = EncodeCursor(&page.Records[len(page.Records)-1])
+	}
+	return page
+}
+
+// EncodeCursor returns an opaque cursor pointing after the given record
+func EncodeCursor(record *TelemetryData) string {
+	raw := strconv.FormatInt(record.Timestamp.UnixNano(), 10) + "|" + record.UUID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor returns the timestamp and UUID of the last record of the previous page
+func DecodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	ns, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return time.Unix(0, ns).UTC(), parts[1], nil
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
@@ -0,0 +1,173 @@
+package schema
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+
+func TestCursor(t *testing.T) {
+	record := &TelemetryData{Timestamp: base.Add(123 * time.Nanosecond), UUID: "a|b"}
+	ts, uuid, err := DecodeCursor(EncodeCursor(record))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ts.Equal(record.Timestamp) || uuid != record.UUID {
+		t.Errorf("decoded %v, %q, want %v, %q", ts, uuid, record.Timestamp, record.UUID)
+	}
+
+	for _, cursor := range []string{
+		"not base64!",
+		base64.URLEncoding.EncodeToString([]byte("1|ab")), // padded
+		base64.RawURLEncoding.EncodeToString([]byte("no separator")),
+		base64.RawURLEncoding.EncodeToString([]byte("nan|uuid")),
+		base64.RawURLEncoding.EncodeToString([]byte("99999999999999999999|uuid")),
+	} {
+		if _, _, err := DecodeCursor(cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("DecodeCursor(%q) = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	tests := []struct {
+		limit int
+		want  int
+	}{
+		{0, DefaultPageSize},
+		{-1, DefaultPageSize},
+		{10, 10},
+		{MaxPageSize, MaxPageSize},
+		{MaxPageSize + 1, MaxPageSize},
+	}
+	for _, tt := range tests {
+		if got := (Query{Limit: tt.limit}).PageSize(); got != tt.want {
+			t.Errorf("PageSize() with limit %d = %d, want %d", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	record := &TelemetryData{
+		Timestamp: base,
+		IPAddress: "192.0.2.1",
+		ISPInfo:   `{"processedString":"192.0.2.1 - Example ISP"}`,
+		UserAgent: "Mozilla/5.0 Firefox/120.0",
+	}
+	tests := []struct {
+		name string
+		q    Query
+		want bool
+	}{
+		{"no filters", Query{}, true},
+		{"from is inclusive", Query{From: base}, true},
+		{"after from", Query{From: base.Add(time.Second)}, false},
+		{"to is exclusive", Query{To: base}, false},
+		{"before to", Query{To: base.Add(time.Second)}, true},
+		{"same IP", Query{IPAddress: "192.0.2.1"}, true},
+		{"IP prefix", Query{IPAddress: "192.0.2"}, false},
+		{"ISP substring", Query{ISP: "example isp"}, true},
+		{"other ISP", Query{ISP: "Other"}, false},
+		{"user agent substring", Query{UserAgent: "firefox"}, true},
+		{"other user agent", Query{UserAgent: "Chrome"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.q.Matches(record); got != tt.want {
+			t.Errorf("%s: Matches() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// pagingRecords returns records with timestamp ties, in no particular order
+func pagingRecords() []TelemetryData {
+	return []TelemetryData{
+		{Timestamp: base.Add(time.Minute), UUID: "c"},
+		{Timestamp: base, UUID: "b"},
+		{Timestamp: base.Add(2 * time.Minute), UUID: "a"},
+		{Timestamp: base, UUID: "d"},
+		{Timestamp: base.Add(time.Minute), UUID: "a"},
+		{Timestamp: base, UUID: "a"},
+		{Timestamp: base.Add(-time.Hour), UUID: "x", IPAddress: "198.51.100.1"},
+	}
+}
+
+func TestApplyPagination(t *testing.T) {
+	tests := []struct {
+		order SortOrder
+		limit int
+		want  []string
+	}{
+		{OldestFirst, 2, []string{"0a", "0b", "0d", "1a", "1c", "2a"}},
+		{NewestFirst, 2, []string{"2a", "1c", "1a", "0d", "0b", "0a"}},
+		{NewestFirst, 4, []string{"2a", "1c", "1a", "0d", "0b", "0a"}},
+		{OldestFirst, 6, []string{"0a", "0b", "0d", "1a", "1c", "2a"}},
+	}
+	for _, tt := range tests {
+		q := Query{From: base, Order: tt.order, Limit: tt.limit}
+		var got []string
+		for pages := 0; ; pages++ {
+			if pages > len(tt.want) {
+				t.Fatalf("order %d, limit %d: too many pages", tt.order, tt.limit)
+			}
+			// backends fetch one record more than the page size
+			page, err := q.Apply(pagingRecords())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Records) > tt.limit {
+				t.Fatalf("order %d, limit %d: page of %d records", tt.order, tt.limit, len(page.Records))
+			}
+			for _, r := range page.Records {
+				got = append(got, string('0'+rune(r.Timestamp.Sub(base)/time.Minute))+r.UUID)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+
+		if len(got) != len(tt.want) {
+			t.Fatalf("order %d, limit %d: got %v, want %v", tt.order, tt.limit, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("order %d, limit %d: got %v, want %v", tt.order, tt.limit, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestApplyInvalidCursor(t *testing.T) {
+	if _, err := (Query{Cursor: "!"}).Apply(pagingRecords()); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Apply() = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestLess(t *testing.T) {
+	a := &TelemetryData{Timestamp: base, UUID: "a"}
+	b := &TelemetryData{Timestamp: base, UUID: "b"}
+	later := &TelemetryData{Timestamp: base.Add(time.Nanosecond), UUID: "a"}
+
+	tests := []struct {
+		order SortOrder
+		x, y  *TelemetryData
+		want  bool
+	}{
+		{OldestFirst, a, b, true},
+		{OldestFirst, b, a, false},
+		{OldestFirst, b, later, true},
+		{OldestFirst, a, a, false},
+		{NewestFirst, a, b, false},
+		{NewestFirst, b, a, true},
+		{NewestFirst, later, b, true},
+		{NewestFirst, a, a, false},
+	}
+	for i, tt := range tests {
+		if got := (Query{Order: tt.order}).Less(tt.x, tt.y); got != tt.want {
+			t.Errorf("case %d: Less() = %v, want %v", i, got, tt.want)
+		}
+	}
+}
@@ -4,6 +4,21 @@ import (
 	"time"
 )
 
+// TelemetryData is a single test result. Download and Upload are in Mbit/s, Ping and
+// Jitter in milliseconds. A value of 0 means the measurement failed or was not run.
+//
+// ServerDownload and ServerUpload are the throughput measured by the server for the
+// test session, also in Mbit/s. Flags lists the reasons, comma separated, why the
+// reported result doesn't look plausible. Site is the label of the internal network the
+// test was run from, if it is in the site mapping.
+//
+// ServerPing and ServerJitter are the latency in milliseconds measured by the server
+// over WebSocket, PacketLoss the percentage of its probes that were not answered.
+//
+// LoadedPingDownload and LoadedPingUpload are the median latency in milliseconds while the
+// download and upload tests were running, LoadedPacketLoss the percentage of probes lost
+// meanwhile, Bufferbloat the grade of the latency increase and LoadedLatency the probes as
+// a JSON array.
 type TelemetryData struct {
 	Timestamp time.Time
 	IPAddress string
@@ -11,10 +26,25 @@ type TelemetryData struct {
 	Extra     string
 	UserAgent string
 	Language  string
-	Download  string
-	Upload    string
-	Ping      string
-	Jitter    string
+	Download  float64
+	Upload    float64
+	Ping      float64
+	Jitter    float64
 	Log       string
 	UUID      string
+
+	ServerDownload float64
+	ServerUpload   float64
+	Flags          string
+	Site           string
+
+	ServerPing   float64
+	ServerJitter float64
+	PacketLoss   float64
+
+	LoadedPingDownload float64
+	LoadedPingUpload   float64
+	LoadedPacketLoss   float64
+	Bufferbloat        string
+	LoadedLatency      string
 }
@@ -0,0 +1,102 @@
+package sqlite
+
+import (
+	"github.com/librespeed/speedtest/database/migration"
+)
+
+// BEGIN IMMEDIATE takes the write lock of the database file before the schema version is
+// read, so instances starting at the same time wait for each other
+var migrationDialect = migration.Dialect{
+	Begin: `BEGIN IMMEDIATE`,
+}
+
+var migrations = []migration.Migration{
+	{
+		Version:     1,
+		Description: "create speedtest_users table",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS speedtest_users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				"timestamp" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				ip TEXT NOT NULL,
+				ispinfo TEXT,
+				extra TEXT,
+				ua TEXT NOT NULL,
+				lang TEXT NOT NULL,
+				dl TEXT,
+				ul TEXT,
+				ping TEXT,
+				jitter TEXT,
+				log TEXT,
+				uuid TEXT
+			)`,
+			`CREATE INDEX IF NOT EXISTS speedtest_users_uuid ON speedtest_users (uuid)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "convert dl, ul, ping and jitter to numeric columns",
+		// SQLite cannot change column types in place, so the table is rebuilt
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS speedtest_users_new (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				"timestamp" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				ip TEXT NOT NULL,
+				ispinfo TEXT,
+				extra TEXT,
+				ua TEXT NOT NULL,
+				lang TEXT NOT NULL,
+				dl REAL NOT NULL DEFAULT 0,
+				ul REAL NOT NULL DEFAULT 0,
+				ping REAL NOT NULL DEFAULT 0,
+				jitter REAL NOT NULL DEFAULT 0,
+				log TEXT,
+				uuid TEXT
+			)`,
+			`INSERT INTO speedtest_users_new (id, "timestamp", ip, ispinfo, extra, ua, lang, dl, ul, ping, jitter, log, uuid)
+				SELECT id, "timestamp", ip, ispinfo, extra, ua, lang,
+					COALESCE(CAST(dl AS REAL), 0), COALESCE(CAST(ul AS REAL), 0), COALESCE(CAST(ping AS REAL), 0), COALESCE(CAST(jitter AS REAL), 0),
+					log, uuid
+				FROM speedtest_users`,
+			`DROP TABLE speedtest_users`,
+			`ALTER TABLE speedtest_users_new RENAME TO speedtest_users`,
+			`CREATE INDEX IF NOT EXISTS speedtest_users_uuid ON speedtest_users (uuid)`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "add server side measurements and plausibility flags",
+		Statements: []string{
+			`ALTER TABLE speedtest_users ADD COLUMN server_dl REAL NOT NULL DEFAULT 0`,
+			`ALTER TABLE speedtest_users ADD COLUMN server_ul REAL NOT NULL DEFAULT 0`,
+			`ALTER TABLE speedtest_users ADD COLUMN flags TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "add site of internal networks",
+		Statements: []string{
+			`ALTER TABLE speedtest_users ADD COLUMN site TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "add server side latency measurements",
+		Statements: []string{
+			`ALTER TABLE speedtest_users ADD COLUMN server_ping REAL NOT NULL DEFAULT 0`,
+			`ALTER TABLE speedtest_users ADD COLUMN server_jitter REAL NOT NULL DEFAULT 0`,
+			`ALTER TABLE speedtest_users ADD COLUMN packet_loss REAL NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		Version:     6,
+		Description: "add latency under load",
+		Statements: []string{
+			`ALTER TABLE speedtest_users ADD COLUMN loaded_ping_dl REAL NOT NULL DEFAULT 0`,
+			`ALTER TABLE speedtest_users ADD COLUMN loaded_ping_ul REAL NOT NULL DEFAULT 0`,
+			`ALTER TABLE speedtest_users ADD COLUMN loaded_packet_loss REAL NOT NULL DEFAULT 0`,
+			`ALTER TABLE speedtest_users ADD COLUMN bufferbloat TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE speedtest_users ADD COLUMN loaded_latency TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+}
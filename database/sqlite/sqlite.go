@@ -0,0 +1,70 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/librespeed/speedtest/database/migration"
+	"github.com/librespeed/speedtest/database/schema"
+	"github.com/librespeed/speedtest/database/sqlquery"
+
+	log "github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	connectionStringTemplate = `file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)`
+)
+
+type SQLite struct {
+	db *sql.DB
+}
+
+func Open(databaseFile string) *SQLite {
+	conn, err := sql.Open("sqlite", fmt.Sprintf(connectionStringTemplate, databaseFile))
+	if err != nil {
+		log.Fatalf("Cannot open SQLite database: %s", err)
+	}
+
+	// SQLite only allows a single writer at a time, serialize access in the pool
+	// to avoid SQLITE_BUSY errors under concurrent telemetry submissions
+	conn.SetMaxOpenConns(1)
+
+	if err := migration.Run(conn, migrationDialect, migrations); err != nil {
+		log.Fatalf("Cannot migrate SQLite database schema: %s", err)
+	}
+	return &SQLite{db: conn}
+}
+
+func (p *SQLite) Insert(data *schema.TelemetryData) error {
+	stmt := `INSERT INTO speedtest_users (ip, ispinfo, extra, ua, lang, dl, ul, ping, jitter, log, uuid, server_dl, server_ul, flags, site, server_ping, server_jitter, packet_loss, loaded_ping_dl, loaded_ping_ul, loaded_packet_loss, bufferbloat, loaded_latency) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err := p.db.Exec(stmt, data.IPAddress, data.ISPInfo, data.Extra, data.UserAgent, data.Language, data.Download, data.Upload, data.Ping, data.Jitter, data.Log, data.UUID, data.ServerDownload, data.ServerUpload, data.Flags, data.Site, data.ServerPing, data.ServerJitter, data.PacketLoss,
+		data.LoadedPingDownload, data.LoadedPingUpload, data.LoadedPacketLoss, data.Bufferbloat, data.LoadedLatency)
+	return err
+}
+
+func (p *SQLite) FetchByUUID(uuid string) (*schema.TelemetryData, error) {
+	row := p.db.QueryRow(`SELECT `+sqlquery.SQLite.Columns()+` FROM speedtest_users WHERE uuid = ?`, uuid)
+	record, err := sqlquery.Scan(row)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (p *SQLite) Query(q schema.Query) (*schema.Page, error) {
+	return sqlquery.SQLite.Query(p.db, q)
+}
+
+func (p *SQLite) Aggregate(q schema.AggregateQuery) ([]schema.Aggregate, error) {
+	return sqlquery.SQLite.Aggregate(p.db, q)
+}
+
+func (p *SQLite) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+func (p *SQLite) Close() error {
+	return p.db.Close()
+}
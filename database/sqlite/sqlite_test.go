@@ -0,0 +1,106 @@
+package sqlite
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest/database/schema"
+)
+
+var base = time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+
+func openTemp(t *testing.T) *SQLite {
+	t.Helper()
+	db := Open(filepath.Join(t.TempDir(), "speedtest.db"))
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// insertRecords stores records with the given timestamps, which CURRENT_TIMESTAMP can't provide
+func insertRecords(t *testing.T, db *SQLite, records []schema.TelemetryData) {
+	t.Helper()
+	for i := range records {
+		if err := db.Insert(&records[i]); err != nil {
+			t.Fatal(err)
+		}
+		ts := records[i].Timestamp.UTC().Format("2006-01-02 15:04:05")
+		if _, err := db.db.Exec(`UPDATE speedtest_users SET "timestamp" = ? WHERE uuid = ?`, ts, records[i].UUID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// pageThrough follows the cursors of q and returns the UUIDs of all records
+func pageThrough(t *testing.T, db *SQLite, q schema.Query) string {
+	t.Helper()
+	var uuids []string
+	for {
+		page, err := db.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range page.Records {
+			uuids = append(uuids, r.UUID)
+		}
+		if page.NextCursor == "" {
+			return strings.Join(uuids, ",")
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+func TestQuery(t *testing.T) {
+	db := openTemp(t)
+	insertRecords(t, db, []schema.TelemetryData{
+		{Timestamp: base.Add(time.Hour), UUID: "c", IPAddress: "192.0.2.1", UserAgent: "curl", ISPInfo: "Example ISP"},
+		{Timestamp: base, UUID: "b", IPAddress: "192.0.2.1", UserAgent: "Firefox", ISPInfo: "100%_ISP"},
+		{Timestamp: base, UUID: "a", IPAddress: "192.0.2.1", UserAgent: "Firefox"},
+		{Timestamp: base.Add(time.Hour), UUID: "d", IPAddress: "192.0.2.1", UserAgent: "firefox"},
+		{Timestamp: base.Add(2 * time.Hour), UUID: "e", IPAddress: "198.51.100.1", UserAgent: "Firefox"},
+	})
+
+	tests := []struct {
+		name string
+		q    schema.Query
+		want string
+	}{
+		{"newest first", schema.Query{Limit: 2}, "e,d,c,b,a"},
+		{"oldest first", schema.Query{Order: schema.OldestFirst, Limit: 2}, "a,b,c,d,e"},
+		{"single page", schema.Query{Order: schema.OldestFirst}, "a,b,c,d,e"},
+		{"ip address", schema.Query{IPAddress: "192.0.2.1", Limit: 3}, "d,c,b,a"},
+		{"user agent", schema.Query{UserAgent: "FIREFOX", Order: schema.OldestFirst, Limit: 1}, "a,b,d,e"},
+		{"isp wildcards are literal", schema.Query{ISP: "%_"}, "b"},
+		{"time range", schema.Query{From: base.Add(time.Hour), To: base.Add(2 * time.Hour), Limit: 1}, "d,c"},
+	}
+	for _, tt := range tests {
+		if got := pageThrough(t, db, tt.q); got != tt.want {
+			t.Errorf("%s: paged through %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	page, err := db.Query(schema.Query{Order: schema.OldestFirst, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := page.Records[0]; !r.Timestamp.Equal(base) || r.UserAgent != "Firefox" {
+		t.Errorf("first record = %s %q, want %s %q", r.Timestamp, r.UserAgent, base, "Firefox")
+	}
+
+	if _, err := db.Query(schema.Query{Cursor: "%"}); !errors.Is(err, schema.ErrInvalidCursor) {
+		t.Errorf("Query() with malformed cursor = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestQueryEmpty(t *testing.T) {
+	db := openTemp(t)
+	page, err := db.Query(schema.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Records) != 0 || page.NextCursor != "" {
+		t.Errorf("Query() on empty database = %+v", page)
+	}
+}
@@ -0,0 +1,188 @@
+package sqlquery
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/librespeed/speedtest/database/schema"
+)
+
+// Dialect describes the differences between the supported SQL databases
+type Dialect struct {
+	// Placeholder returns the bind parameter for the n-th (1-based) argument
+	Placeholder func(n int) string
+	// TimestampColumn is the quoted name of the timestamp column
+	TimestampColumn string
+	// Like is the case-insensitive pattern matching operator
+	Like string
+	// Time converts a time for comparison with the timestamp column
+	Time func(time.Time) interface{}
+}
+
+var (
+	MySQL = Dialect{
+		Placeholder:     func(int) string { return "?" },
+		TimestampColumn: "`timestamp`",
+		Like:            "LIKE",
+		Time:            func(t time.Time) interface{} { return t.UTC() },
+	}
+
+	PostgreSQL = Dialect{
+		Placeholder:     func(n int) string { return fmt.Sprintf("$%d", n) },
+		TimestampColumn: `"timestamp"`,
+		Like:            "ILIKE",
+		Time:            func(t time.Time) interface{} { return t.UTC() },
+	}
+
+	// SQLite stores CURRENT_TIMESTAMP as text, so times have to be compared in the same format
+	SQLite = Dialect{
+		Placeholder:     func(int) string { return "?" },
+		TimestampColumn: `"timestamp"`,
+		Like:            "LIKE",
+		Time:            func(t time.Time) interface{} { return t.UTC().Format("2006-01-02 15:04:05") },
+	}
+)
+
+// Columns returns the column list matching the order expected by Scan
+func (d Dialect) Columns() string {
+	return d.TimestampColumn + ", ip, ispinfo, extra, ua, lang, dl, ul, ping, jitter, log, uuid, server_dl, server_ul, COALESCE(flags, ''), COALESCE(site, ''), server_ping, server_jitter, packet_loss, " +
+		"loaded_ping_dl, loaded_ping_ul, loaded_packet_loss, COALESCE(bufferbloat, ''), COALESCE(loaded_latency, '')"
+}
+
+// where builds the WHERE clause for the time range and field filters of the query
+func (d Dialect) where(q schema.Query) (string, []interface{}) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return d.Placeholder(len(args))
+	}
+
+	if !q.From.IsZero() {
+		conditions = append(conditions, d.TimestampColumn+" >= "+arg(d.Time(q.From)))
+	}
+	if !q.To.IsZero() {
+		conditions = append(conditions, d.TimestampColumn+" < "+arg(d.Time(q.To)))
+	}
+	if q.IPAddress != "" {
+		conditions = append(conditions, "ip = "+arg(q.IPAddress))
+	}
+	if q.ISP != "" {
+		conditions = append(conditions, "ispinfo "+d.Like+" "+arg(likePattern(q.ISP))+" ESCAPE '!'")
+	}
+	if q.UserAgent != "" {
+		conditions = append(conditions, "ua "+d.Like+" "+arg(likePattern(q.UserAgent))+" ESCAPE '!'")
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// Select builds a SELECT statement for the query, fetching one record more than the page size
+func (d Dialect) Select(q schema.Query) (string, []interface{}, error) {
+	where, args := d.where(q)
+
+	direction, comparison := "DESC", "<"
+	if q.Order == schema.OldestFirst {
+		direction, comparison = "ASC", ">"
+	}
+
+	if q.Cursor != "" {
+		ts, uuid, err := schema.DecodeCursor(q.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if where == "" {
+			where = " WHERE "
+		} else {
+			where += " AND "
+		}
+		n := len(args)
+		where += fmt.Sprintf("(%[1]s %[2]s %[3]s OR (%[1]s = %[4]s AND uuid %[2]s %[5]s))",
+			d.TimestampColumn, comparison, d.Placeholder(n+1), d.Placeholder(n+2), d.Placeholder(n+3))
+		args = append(args, d.Time(ts), d.Time(ts), uuid)
+	}
+
+	stmt := "SELECT " + d.Columns() + " FROM speedtest_users" + where
+	stmt += fmt.Sprintf(" ORDER BY %[1]s %[2]s, uuid %[2]s LIMIT %[3]d", d.TimestampColumn, direction, q.PageSize()+1)
+
+	return stmt, args, nil
+}
+
+// Scan reads a row selected with Columns
+func Scan(row interface{ Scan(...interface{}) error }) (schema.TelemetryData, error) {
+	var record schema.TelemetryData
+	err := row.Scan(&record.Timestamp, &record.IPAddress, &record.ISPInfo, &record.Extra, &record.UserAgent, &record.Language, &record.Download, &record.Upload, &record.Ping, &record.Jitter, &record.Log, &record.UUID, &record.ServerDownload, &record.ServerUpload, &record.Flags, &record.Site, &record.ServerPing, &record.ServerJitter, &record.PacketLoss,
+		&record.LoadedPingDownload, &record.LoadedPingUpload, &record.LoadedPacketLoss, &record.Bufferbloat, &record.LoadedLatency)
+	return record, err
+}
+
+// Query runs the query against db and returns the requested page
+func (d Dialect) Query(db *sql.DB, q schema.Query) (*schema.Page, error) {
+	stmt, args, err := d.Select(q)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []schema.TelemetryData
+	for rows.Next() {
+		record, err := Scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return q.NewPage(records), nil
+}
+
+// Aggregate streams the columns needed for aggregation through a schema.Aggregator, as
+// percentiles cannot be computed portably in SQL
+func (d Dialect) Aggregate(db *sql.DB, q schema.AggregateQuery) ([]schema.Aggregate, error) {
+	where, args := d.where(q.Query)
+	stmt := "SELECT " + d.TimestampColumn + ", ispinfo, dl, ul, ping, jitter FROM speedtest_users" + where
+
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aggregator := schema.NewAggregator(q)
+	for rows.Next() {
+		var record schema.TelemetryData
+		var ispInfo sql.NullString
+		if err := rows.Scan(&record.Timestamp, &ispInfo, &record.Download, &record.Upload, &record.Ping, &record.Jitter); err != nil {
+			return nil, err
+		}
+		record.ISPInfo = ispInfo.String
+		aggregator.Add(&record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return aggregator.Result(), nil
+}
+
+func likePattern(s string) string {
+	r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return "%" + r.Replace(s) + "%"
+}
@@ -0,0 +1,174 @@
+package ispinfo
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/librespeed/speedtest/metrics"
+	"github.com/librespeed/speedtest/results"
+)
+
+// Cache is a bounded LRU cache in front of a Provider. Failed lookups are cached as well,
+// for a shorter time, so that an unavailable or rate limiting service isn't hammered.
+// Concurrent misses of the same key share a single provider lookup, and the cache is
+// purged when the provider reloads its data.
+type Cache struct {
+	provider Provider
+
+	lock        sync.Mutex
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	ipv4Mask    net.IPMask
+	ipv6Mask    net.IPMask
+
+	entries map[string]*list.Element
+	order   *list.List
+	// generation is incremented by Purge, so that lookups started before don't store
+	// outdated results
+	generation uint64
+
+	misses singleflight.Group
+}
+
+type cacheEntry struct {
+	key     string
+	info    results.IPInfoResponse
+	found   bool
+	failed  bool
+	expires time.Time
+}
+
+// NewCache returns a cache of up to size entries. Addresses are grouped by the given
+// prefix lengths, 32 and 128 cache every address separately.
+func NewCache(provider Provider, size int, ttl, negativeTTL time.Duration, ipv4Prefix, ipv6Prefix int) (*Cache, error) {
+	// net.CIDRMask returns nil for invalid lengths, which would map all addresses to one entry
+	if ipv4Prefix < 0 || ipv4Prefix > 32 {
+		return nil, fmt.Errorf("IPv4 prefix length must be between 0 and 32, not %d", ipv4Prefix)
+	}
+	if ipv6Prefix < 0 || ipv6Prefix > 128 {
+		return nil, fmt.Errorf("IPv6 prefix length must be between 0 and 128, not %d", ipv6Prefix)
+	}
+
+	c := &Cache{
+		provider:    provider,
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		ipv4Mask:    net.CIDRMask(ipv4Prefix, 32),
+		ipv6Mask:    net.CIDRMask(ipv6Prefix, 128),
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+	}
+	if r, ok := provider.(Reloader); ok {
+		r.OnReload(c.Purge)
+	}
+	return c, nil
+}
+
+// Lookup returns the cached result for addr, asking the provider on a miss. Cached
+// failures are reported as not found.
+func (c *Cache) Lookup(addr string) (results.IPInfoResponse, bool, error) {
+	key := c.key(addr)
+
+	c.lock.Lock()
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(el)
+			c.lock.Unlock()
+
+			if entry.failed {
+				metrics.ISPCacheLookups.WithLabelValues(metrics.CacheNegativeHit).Inc()
+			} else {
+				metrics.ISPCacheLookups.WithLabelValues(metrics.CacheHit).Inc()
+			}
+			return forAddress(entry.info, addr), entry.found, nil
+		}
+		c.remove(el)
+	}
+	generation := c.generation
+	c.lock.Unlock()
+
+	metrics.ISPCacheLookups.WithLabelValues(metrics.CacheMiss).Inc()
+	v, err, _ := c.misses.Do(key, func() (interface{}, error) {
+		return c.fetch(key, addr, generation)
+	})
+	entry := v.(*cacheEntry)
+	return forAddress(entry.info, addr), entry.found, err
+}
+
+// fetch asks the provider and caches the result, unless the cache was purged meanwhile
+func (c *Cache) fetch(key, addr string, generation uint64) (*cacheEntry, error) {
+	now := time.Now()
+	info, found, err := c.provider.Lookup(addr)
+
+	entry := &cacheEntry{key: key, info: info, found: found, failed: err != nil, expires: now.Add(c.ttl)}
+	if err != nil {
+		entry.expires = now.Add(c.negativeTTL)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if generation != c.generation {
+		return entry, err
+	}
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	metrics.ISPCacheEntries.Set(float64(c.order.Len()))
+
+	return entry, err
+}
+
+// Purge removes all entries
+func (c *Cache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.generation++
+	metrics.ISPCacheEntries.Set(0)
+}
+
+// forAddress adapts info cached for a prefix to addr, the hostname belongs to a single
+// address
+func forAddress(info results.IPInfoResponse, addr string) results.IPInfoResponse {
+	if info.IP != addr {
+		info.IP = addr
+		info.Hostname = ""
+	}
+	return info
+}
+
+// Check checks the provider, bypassing the cache
+func (c *Cache) Check() error {
+	return Check(c.provider)
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+func (c *Cache) key(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(c.ipv4Mask).String()
+	}
+	return ip.Mask(c.ipv6Mask).String()
+}
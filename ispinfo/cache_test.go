@@ -0,0 +1,182 @@
+package ispinfo
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest/config"
+	"github.com/librespeed/speedtest/results"
+)
+
+// countingProvider counts lookups, each one waits until release is closed
+type countingProvider struct {
+	lookups  atomic.Int32
+	release  chan struct{}
+	org      string
+	fail     bool
+	onReload func()
+}
+
+func (p *countingProvider) Lookup(addr string) (results.IPInfoResponse, bool, error) {
+	p.lookups.Add(1)
+	<-p.release
+	if p.fail {
+		return results.IPInfoResponse{}, false, errors.New("unavailable")
+	}
+	return results.IPInfoResponse{IP: addr, Hostname: "host-" + addr, Organization: p.org}, true, nil
+}
+
+func (p *countingProvider) OnReload(f func()) {
+	p.onReload = f
+}
+
+func newCountingProvider() *countingProvider {
+	p := &countingProvider{release: make(chan struct{}), org: "AS1"}
+	close(p.release)
+	return p
+}
+
+func TestCacheSharesConcurrentMisses(t *testing.T) {
+	p := &countingProvider{release: make(chan struct{}), org: "AS1"}
+	c := newTestCache(t, p, 10, time.Hour, time.Minute, 24, 48)
+
+	var wg sync.WaitGroup
+	for _, addr := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.1", "192.0.2.3"} {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			info, found, err := c.Lookup(addr)
+			if err != nil || !found {
+				t.Errorf("Lookup(%s) = %v, %v", addr, found, err)
+			}
+			if info.IP != addr {
+				t.Errorf("Lookup(%s) returned info for %s", addr, info.IP)
+			}
+		}(addr)
+	}
+	// let all lookups join the first one before it completes
+	time.Sleep(50 * time.Millisecond)
+	close(p.release)
+	wg.Wait()
+
+	if n := p.lookups.Load(); n != 1 {
+		t.Errorf("provider was asked %d times, want 1", n)
+	}
+}
+
+func TestCacheHitsAndPrefixes(t *testing.T) {
+	p := newCountingProvider()
+	c := newTestCache(t, p, 10, time.Hour, time.Minute, 24, 48)
+
+	for _, addr := range []string{"192.0.2.1", "192.0.2.1", "192.0.2.200", "2001:db8::1", "2001:db8::2"} {
+		info, _, _ := c.Lookup(addr)
+		if info.IP != addr {
+			t.Errorf("Lookup(%s) returned info for %s", addr, info.IP)
+		}
+		if info.Hostname != "" && info.Hostname != "host-"+addr {
+			t.Errorf("Lookup(%s) returned hostname %s", addr, info.Hostname)
+		}
+	}
+	if n := p.lookups.Load(); n != 2 {
+		t.Errorf("provider was asked %d times, want 2", n)
+	}
+}
+
+func TestCacheNegativeEntries(t *testing.T) {
+	p := newCountingProvider()
+	p.fail = true
+	c := newTestCache(t, p, 10, time.Hour, time.Hour, 32, 128)
+
+	if _, _, err := c.Lookup("192.0.2.1"); err == nil {
+		t.Error("first lookup didn't report the failure")
+	}
+	if _, found, err := c.Lookup("192.0.2.1"); err != nil || found {
+		t.Errorf("cached failure = %v, %v, want not found", found, err)
+	}
+	if n := p.lookups.Load(); n != 1 {
+		t.Errorf("provider was asked %d times, want 1", n)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	p := newCountingProvider()
+	c := newTestCache(t, p, 2, time.Hour, time.Minute, 32, 128)
+
+	for _, addr := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.1", "192.0.2.3", "192.0.2.1", "192.0.2.2"} {
+		_, _, _ = c.Lookup(addr)
+	}
+	// .2 was evicted by .3 as the least recently used entry
+	if n := p.lookups.Load(); n != 4 {
+		t.Errorf("provider was asked %d times, want 4", n)
+	}
+}
+
+func TestCachePurgedOnReload(t *testing.T) {
+	p := newCountingProvider()
+	c := newTestCache(t, p, 10, time.Hour, time.Minute, 32, 128)
+	if p.onReload == nil {
+		t.Fatal("cache didn't register for reloads")
+	}
+
+	if info, _, _ := c.Lookup("192.0.2.1"); info.Organization != "AS1" {
+		t.Fatalf("organization = %q", info.Organization)
+	}
+	p.org = "AS2"
+	p.onReload()
+	if info, _, _ := c.Lookup("192.0.2.1"); info.Organization != "AS2" {
+		t.Errorf("organization = %q after reload, want AS2", info.Organization)
+	}
+}
+
+func TestFallbackForwardsReloads(t *testing.T) {
+	primary := newCountingProvider()
+	f := &fallback{primary: primary, secondary: newCountingProvider()}
+	c := newTestCache(t, f, 10, time.Hour, time.Minute, 32, 128)
+
+	_, _, _ = c.Lookup("192.0.2.1")
+	primary.onReload()
+	_, _, _ = c.Lookup("192.0.2.1")
+	if n := primary.lookups.Load(); n != 2 {
+		t.Errorf("provider was asked %d times, want 2", n)
+	}
+}
+
+func newTestCache(t *testing.T, provider Provider, size int, ttl, negativeTTL time.Duration, ipv4Prefix, ipv6Prefix int) *Cache {
+	t.Helper()
+	c, err := NewCache(provider, size, ttl, negativeTTL, ipv4Prefix, ipv6Prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestNewCachePrefixLengths(t *testing.T) {
+	tests := []struct {
+		ipv4, ipv6 int
+		ok         bool
+	}{
+		{32, 128, true},
+		{0, 0, true},
+		{24, 64, true},
+		{33, 128, false},
+		{-1, 128, false},
+		{32, 129, false},
+		{32, -8, false},
+	}
+	for _, tt := range tests {
+		_, err := NewCache(newCountingProvider(), 10, time.Hour, time.Minute, tt.ipv4, tt.ipv6)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewCache() with prefixes /%d and /%d = %v, want ok %v", tt.ipv4, tt.ipv6, err, tt.ok)
+		}
+	}
+}
+
+func TestNewRejectsInvalidCachePrefix(t *testing.T) {
+	conf := &config.Config{ISPCacheSize: 10, ISPCacheTTL: time.Hour, ISPCacheIPv4Prefix: 40, ISPCacheIPv6Prefix: 128}
+	if _, err := New(conf); err == nil {
+		t.Error("New() accepted isp_cache_ipv4_prefix=40")
+	}
+}
@@ -0,0 +1,109 @@
+package ipapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/librespeed/speedtest/results"
+)
+
+const (
+	// DefaultURL is the free ip-api.com endpoint, which is only available over plain HTTP
+	DefaultURL = "http://ip-api.com/json/{ip}"
+)
+
+// response is the JSON format of ip-api.com and compatible services
+type response struct {
+	Status      string   `json:"status"`
+	Message     string   `json:"message"`
+	Query       string   `json:"query"`
+	City        string   `json:"city"`
+	RegionName  string   `json:"regionName"`
+	CountryCode string   `json:"countryCode"`
+	Zip         string   `json:"zip"`
+	Lat         *float64 `json:"lat"`
+	Lon         *float64 `json:"lon"`
+	Timezone    string   `json:"timezone"`
+	ISP         string   `json:"isp"`
+	Org         string   `json:"org"`
+	AS          string   `json:"as"`
+	Reverse     string   `json:"reverse"`
+}
+
+// Provider queries an ip-api.com style JSON service
+type Provider struct {
+	client      *http.Client
+	urlTemplate string
+}
+
+// New returns a provider for the service at urlTemplate, in which {ip} is replaced by the
+// address to look up
+func New(urlTemplate string, timeout time.Duration) *Provider {
+	if urlTemplate == "" {
+		urlTemplate = DefaultURL
+	}
+	return &Provider{
+		client:      &http.Client{Timeout: timeout},
+		urlTemplate: urlTemplate,
+	}
+}
+
+func (p *Provider) Lookup(addr string) (results.IPInfoResponse, bool, error) {
+	var ret results.IPInfoResponse
+
+	resp, err := p.client.Get(strings.ReplaceAll(p.urlTemplate, "{ip}", url.PathEscape(addr)))
+	if err != nil {
+		return ret, false, fmt.Errorf("error getting response from ISP info service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ret, false, fmt.Errorf("unexpected status from ISP info service: %s", resp.Status)
+	}
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return ret, false, fmt.Errorf("error parsing response from ISP info service: %w", err)
+	}
+
+	// private and reserved ranges are reported as failures
+	if r.Status == "fail" {
+		return ret, false, nil
+	}
+
+	ret.IP = r.Query
+	ret.Hostname = r.Reverse
+	ret.City = r.City
+	ret.Region = r.RegionName
+	ret.Country = r.CountryCode
+	ret.Postal = r.Zip
+	ret.Timezone = r.Timezone
+	if r.Lat != nil && r.Lon != nil {
+		ret.Location = strconv.FormatFloat(*r.Lat, 'f', 4, 64) + "," + strconv.FormatFloat(*r.Lon, 'f', 4, 64)
+	}
+
+	// "as" is formatted like ipinfo.io's org, "AS<number> <name>", which the frontend and
+	// the statistics expect
+	switch {
+	case r.AS != "":
+		ret.Organization = r.AS
+	case r.ISP != "":
+		ret.Organization = r.ISP
+	default:
+		ret.Organization = r.Org
+	}
+
+	return ret, true, nil
+}
+
+// Check queries a loopback address, which the service answers without details, to see
+// whether it is reachable
+func (p *Provider) Check() error {
+	_, _, err := p.Lookup("127.0.0.1")
+	return err
+}
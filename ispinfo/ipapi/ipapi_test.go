@@ -0,0 +1,91 @@
+package ipapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.TrimPrefix(r.URL.Path, "/json/") {
+		case "192.0.2.1":
+			fmt.Fprint(w, `{"status":"success","query":"192.0.2.1","city":"Berlin","regionName":"Land Berlin",
+				"countryCode":"DE","zip":"10115","lat":52.52,"lon":13.405,"timezone":"Europe/Berlin",
+				"isp":"Example ISP","org":"Example Org","as":"AS64496 Example ISP","reverse":"host.example.net"}`)
+		case "192.0.2.2":
+			fmt.Fprint(w, `{"status":"success","query":"192.0.2.2","isp":"Example ISP","org":"Example Org"}`)
+		case "192.0.2.3":
+			fmt.Fprint(w, `{"status":"success","query":"192.0.2.3","org":"Example Org"}`)
+		case "127.0.0.1", "10.0.0.1":
+			fmt.Fprint(w, `{"status":"fail","message":"reserved range","query":"10.0.0.1"}`)
+		case "192.0.2.4":
+			fmt.Fprint(w, `not json`)
+		default:
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestLookup(t *testing.T) {
+	p := New(newTestServer(t).URL+"/json/{ip}", time.Second)
+
+	info, found, err := p.Lookup("192.0.2.1")
+	if err != nil || !found {
+		t.Fatalf("Lookup() = %v, %v", found, err)
+	}
+	want := []struct{ field, got, want string }{
+		{"ip", info.IP, "192.0.2.1"},
+		{"hostname", info.Hostname, "host.example.net"},
+		{"city", info.City, "Berlin"},
+		{"region", info.Region, "Land Berlin"},
+		{"country", info.Country, "DE"},
+		{"loc", info.Location, "52.5200,13.4050"},
+		{"timezone", info.Timezone, "Europe/Berlin"},
+		{"postal", info.Postal, "10115"},
+		{"org", info.Organization, "AS64496 Example ISP"},
+	}
+	for _, w := range want {
+		if w.got != w.want {
+			t.Errorf("%s = %q, want %q", w.field, w.got, w.want)
+		}
+	}
+	if info, _, _ := p.Lookup("192.0.2.2"); info.Organization != "Example ISP" || info.Location != "" {
+		t.Errorf("without as: org = %q, loc = %q", info.Organization, info.Location)
+	}
+	if info, _, _ := p.Lookup("192.0.2.3"); info.Organization != "Example Org" {
+		t.Errorf("without as and isp: org = %q", info.Organization)
+	}
+
+	if _, found, err := p.Lookup("10.0.0.1"); err != nil || found {
+		t.Errorf("Lookup() of reserved address = %v, %v, want not found", found, err)
+	}
+	if _, _, err := p.Lookup("192.0.2.4"); err == nil {
+		t.Error("Lookup() with invalid response succeeded")
+	}
+	if _, _, err := p.Lookup("192.0.2.5"); err == nil || !strings.Contains(err.Error(), "429") {
+		t.Errorf("Lookup() with error status = %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	srv := newTestServer(t)
+	if err := New(srv.URL+"/json/{ip}", time.Second).Check(); err != nil {
+		t.Errorf("Check() = %v", err)
+	}
+	if err := New(srv.URL+"/unknown/{ip}", time.Second).Check(); err == nil {
+		t.Error("Check() against failing service succeeded")
+	}
+}
+
+func TestNewDefaultURL(t *testing.T) {
+	if p := New("", time.Second); p.urlTemplate != DefaultURL {
+		t.Errorf("urlTemplate = %q, want %q", p.urlTemplate, DefaultURL)
+	}
+}
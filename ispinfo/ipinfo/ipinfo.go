@@ -0,0 +1,73 @@
+package ipinfo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/librespeed/speedtest/results"
+)
+
+// Provider queries the ipinfo.io API
+type Provider struct {
+	client *http.Client
+	apiKey string
+}
+
+func New(apiKey string, timeout time.Duration) *Provider {
+	return &Provider{
+		client: &http.Client{Timeout: timeout},
+		apiKey: apiKey,
+	}
+}
+
+func (p *Provider) url(address string) string {
+	ipInfoURL := `https://ipinfo.io/%s/json`
+	if address != "" {
+		ipInfoURL = fmt.Sprintf(ipInfoURL, address)
+	} else {
+		ipInfoURL = "https://ipinfo.io/json"
+	}
+
+	if p.apiKey != "" {
+		ipInfoURL += "?token=" + p.apiKey
+	}
+
+	return ipInfoURL
+}
+
+// Lookup queries the info for addr, or for the address of this server if addr is empty
+func (p *Provider) Lookup(addr string) (results.IPInfoResponse, bool, error) {
+	var ret results.IPInfoResponse
+
+	resp, err := p.client.Get(p.url(addr))
+	if err != nil {
+		return ret, false, fmt.Errorf("error getting response from ipinfo.io: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ret, false, fmt.Errorf("unexpected status from ipinfo.io: %s", resp.Status)
+	}
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return ret, false, fmt.Errorf("error reading response from ipinfo.io: %w", err)
+	}
+
+	if err := json.Unmarshal(raw, &ret); err != nil {
+		return ret, false, fmt.Errorf("error parsing response from ipinfo.io: %w", err)
+	}
+
+	// ipinfo.io answers with a "bogon" flag instead of details for private and reserved addresses
+	return ret, ret.Organization != "" || ret.Country != "", nil
+}
+
+// Check queries a loopback address, which the service answers without details, to see
+// whether it is reachable
+func (p *Provider) Check() error {
+	_, _, err := p.Lookup("127.0.0.1")
+	return err
+}
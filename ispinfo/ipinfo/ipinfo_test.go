@@ -0,0 +1,93 @@
+package ipinfo
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// redirect sends all requests to the test server instead of ipinfo.io
+type redirect struct {
+	target *url.URL
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme, req.URL.Host = r.target.Scheme, r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newTestProvider(t *testing.T, apiKey string) *Provider {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") != apiKey {
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/192.0.2.1/json":
+			fmt.Fprint(w, `{"ip":"192.0.2.1","city":"Berlin","country":"DE","org":"AS64496 Example ISP"}`)
+		case "/127.0.0.1/json":
+			fmt.Fprint(w, `{"ip":"127.0.0.1","bogon":true}`)
+		case "/json":
+			fmt.Fprint(w, `{"ip":"203.0.113.1","country":"DE","org":"AS64497 Example Hosting"}`)
+		default:
+			fmt.Fprint(w, `not json`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	target, _ := url.Parse(srv.URL)
+	p := New(apiKey, time.Second)
+	p.client.Transport = redirect{target: target}
+	return p
+}
+
+func TestURL(t *testing.T) {
+	tests := []struct {
+		apiKey, addr, want string
+	}{
+		{"", "192.0.2.1", "https://ipinfo.io/192.0.2.1/json"},
+		{"", "", "https://ipinfo.io/json"},
+		{"secret", "192.0.2.1", "https://ipinfo.io/192.0.2.1/json?token=secret"},
+		{"secret", "", "https://ipinfo.io/json?token=secret"},
+	}
+	for _, tt := range tests {
+		if got := New(tt.apiKey, time.Second).url(tt.addr); got != tt.want {
+			t.Errorf("url(%q) with key %q = %q, want %q", tt.addr, tt.apiKey, got, tt.want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	p := newTestProvider(t, "secret")
+
+	info, found, err := p.Lookup("192.0.2.1")
+	if err != nil || !found || info.City != "Berlin" || info.Organization != "AS64496 Example ISP" {
+		t.Errorf("Lookup(192.0.2.1) = %+v, %v, %v", info, found, err)
+	}
+	info, found, err = p.Lookup("")
+	if err != nil || !found || info.IP != "203.0.113.1" {
+		t.Errorf("Lookup() of own address = %+v, %v, %v", info, found, err)
+	}
+	if _, found, err := p.Lookup("127.0.0.1"); err != nil || found {
+		t.Errorf("Lookup() of bogon = %v, %v, want not found", found, err)
+	}
+	if _, _, err := p.Lookup("192.0.2.2"); err == nil {
+		t.Error("Lookup() with invalid response succeeded")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := newTestProvider(t, "secret").Check(); err != nil {
+		t.Errorf("Check() = %v", err)
+	}
+
+	p := newTestProvider(t, "secret")
+	p.apiKey = "wrong"
+	if err := p.Check(); err == nil {
+		t.Error("Check() with rejected token succeeded")
+	}
+}
@@ -0,0 +1,138 @@
+package ispinfo
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librespeed/speedtest/config"
+	"github.com/librespeed/speedtest/ispinfo/ipapi"
+	"github.com/librespeed/speedtest/ispinfo/ipinfo"
+	"github.com/librespeed/speedtest/ispinfo/mmdb"
+	"github.com/librespeed/speedtest/ispinfo/static"
+	"github.com/librespeed/speedtest/results"
+)
+
+// Provider looks up the ISP and location of client addresses, normalized to the
+// ipinfo.io response format the frontend expects
+type Provider interface {
+	// Lookup returns the info for addr. found is false if the provider has no data for
+	// the address, e.g. for private ranges.
+	Lookup(addr string) (info results.IPInfoResponse, found bool, err error)
+}
+
+// Checker is implemented by providers that depend on a remote service
+type Checker interface {
+	// Check returns an error if the provider can't answer lookups at the moment
+	Check() error
+}
+
+// Reloader is implemented by providers whose data can change while the server runs
+type Reloader interface {
+	// OnReload registers f to be called after the data has been reloaded
+	OnReload(f func())
+}
+
+// Check reports whether p is able to answer lookups. Providers without remote
+// dependencies always are.
+func Check(p Provider) error {
+	if c, ok := p.(Checker); ok {
+		return c.Check()
+	}
+	return nil
+}
+
+// New sets up the provider selected by isp_provider, with the ipinfo.io fallback and the
+// cache as configured. Without isp_provider, the local GeoIP databases are used if
+// configured, and ipinfo.io otherwise.
+func New(conf *config.Config) (Provider, error) {
+	name := ProviderName(conf)
+
+	var (
+		p   Provider
+		err error
+	)
+	switch name {
+	case "ipinfo":
+		p = ipinfo.New(conf.IPInfoAPIKey, conf.ISPLookupTimeout)
+	case "ipapi":
+		p = ipapi.New(conf.ISPProviderURL, conf.ISPLookupTimeout)
+	case "mmdb":
+		p, err = mmdb.Open(conf.GeoIPCityDatabase, conf.GeoIPASNDatabase)
+	case "static":
+		p, err = static.Open(conf.ISPStaticFile)
+	default:
+		return nil, fmt.Errorf("unsupported ISP info provider: %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Using %s for ISP info", name)
+
+	if name != "ipinfo" && conf.IPInfoFallback {
+		log.Infof("Falling back to ipinfo.io for addresses unknown to %s", name)
+		p = &fallback{primary: p, secondary: ipinfo.New(conf.IPInfoAPIKey, conf.ISPLookupTimeout)}
+	}
+
+	if conf.ISPCacheSize > 0 {
+		p, err = NewCache(p, conf.ISPCacheSize, conf.ISPCacheTTL, conf.ISPCacheNegativeTTL,
+			conf.ISPCacheIPv4Prefix, conf.ISPCacheIPv6Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid isp_cache_ipv4_prefix or isp_cache_ipv6_prefix: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+// ProviderName returns the provider selected by the configuration
+func ProviderName(conf *config.Config) string {
+	if conf.ISPProvider != "" {
+		return conf.ISPProvider
+	}
+	if conf.GeoIPCityDatabase != "" || conf.GeoIPASNDatabase != "" {
+		return "mmdb"
+	}
+	return "ipinfo"
+}
+
+// UsesIPInfo reports whether the configuration allows queries to ipinfo.io
+func UsesIPInfo(conf *config.Config) bool {
+	if conf.IPInfoFallback || conf.ISPProvider == "ipinfo" {
+		return true
+	}
+	return conf.ISPProvider == "" && conf.GeoIPCityDatabase == "" && conf.GeoIPASNDatabase == ""
+}
+
+// fallback asks secondary for addresses that primary doesn't know or fails to look up
+type fallback struct {
+	primary   Provider
+	secondary Provider
+}
+
+func (f *fallback) Lookup(addr string) (results.IPInfoResponse, bool, error) {
+	info, found, err := f.primary.Lookup(addr)
+	if err != nil {
+		log.Errorf("Error looking up ISP info for %s: %s", addr, err)
+	} else if found {
+		return info, true, nil
+	}
+	return f.secondary.Lookup(addr)
+}
+
+// OnReload registers f with both providers
+func (f *fallback) OnReload(callback func()) {
+	for _, p := range []Provider{f.primary, f.secondary} {
+		if r, ok := p.(Reloader); ok {
+			r.OnReload(callback)
+		}
+	}
+}
+
+// Check succeeds if either provider is available
+func (f *fallback) Check() error {
+	if err := Check(f.primary); err == nil {
+		return nil
+	}
+	return Check(f.secondary)
+}
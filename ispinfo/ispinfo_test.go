@@ -0,0 +1,120 @@
+package ispinfo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/librespeed/speedtest/config"
+	"github.com/librespeed/speedtest/results"
+)
+
+// stubProvider answers every lookup the same way
+type stubProvider struct {
+	org      string
+	found    bool
+	err      error
+	checkErr error
+	lookups  int
+	onReload func()
+}
+
+func (p *stubProvider) Lookup(addr string) (results.IPInfoResponse, bool, error) {
+	p.lookups++
+	return results.IPInfoResponse{IP: addr, Organization: p.org}, p.found, p.err
+}
+
+func (p *stubProvider) Check() error {
+	return p.checkErr
+}
+
+func (p *stubProvider) OnReload(f func()) {
+	p.onReload = f
+}
+
+func TestFallback(t *testing.T) {
+	tests := []struct {
+		name      string
+		primary   *stubProvider
+		org       string
+		secondary int
+	}{
+		{"found", &stubProvider{org: "AS1 Primary", found: true}, "AS1 Primary", 0},
+		{"not found", &stubProvider{}, "AS2 Secondary", 1},
+		{"error", &stubProvider{err: errors.New("unavailable")}, "AS2 Secondary", 1},
+	}
+	for _, tt := range tests {
+		secondary := &stubProvider{org: "AS2 Secondary", found: true}
+		f := &fallback{primary: tt.primary, secondary: secondary}
+
+		info, found, err := f.Lookup("192.0.2.1")
+		if err != nil || !found || info.Organization != tt.org {
+			t.Errorf("%s: Lookup() = %+v, %v, %v, want %q", tt.name, info, found, err, tt.org)
+		}
+		if secondary.lookups != tt.secondary {
+			t.Errorf("%s: %d secondary lookups, want %d", tt.name, secondary.lookups, tt.secondary)
+		}
+	}
+}
+
+func TestFallbackCheck(t *testing.T) {
+	down := errors.New("down")
+	tests := []struct {
+		primary, secondary error
+		ok                 bool
+	}{
+		{nil, nil, true},
+		{down, nil, true},
+		{nil, down, true},
+		{down, down, false},
+	}
+	for _, tt := range tests {
+		f := &fallback{primary: &stubProvider{checkErr: tt.primary}, secondary: &stubProvider{checkErr: tt.secondary}}
+		if err := Check(f); (err == nil) != tt.ok {
+			t.Errorf("Check() with primary %v and secondary %v = %v", tt.primary, tt.secondary, err)
+		}
+	}
+}
+
+func TestFallbackOnReload(t *testing.T) {
+	primary, secondary := &stubProvider{}, &stubProvider{}
+	calls := 0
+	(&fallback{primary: primary, secondary: secondary}).OnReload(func() { calls++ })
+	if primary.onReload == nil || secondary.onReload == nil {
+		t.Fatal("callback not registered with both providers")
+	}
+	primary.onReload()
+	secondary.onReload()
+	if calls != 2 {
+		t.Errorf("%d calls, want 2", calls)
+	}
+}
+
+func TestProviderName(t *testing.T) {
+	tests := []struct {
+		name       string
+		conf       config.Config
+		provider   string
+		usesIPInfo bool
+	}{
+		{"default", config.Config{}, "ipinfo", true},
+		{"geoip databases", config.Config{GeoIPASNDatabase: "asn.mmdb"}, "mmdb", false},
+		{"geoip with fallback", config.Config{GeoIPCityDatabase: "city.mmdb", IPInfoFallback: true}, "mmdb", true},
+		{"explicit provider", config.Config{ISPProvider: "static", GeoIPASNDatabase: "asn.mmdb"}, "static", false},
+		{"explicit ipinfo", config.Config{ISPProvider: "ipinfo"}, "ipinfo", true},
+		{"ipapi with fallback", config.Config{ISPProvider: "ipapi", IPInfoFallback: true}, "ipapi", true},
+	}
+	for _, tt := range tests {
+		if got := ProviderName(&tt.conf); got != tt.provider {
+			t.Errorf("%s: ProviderName() = %q, want %q", tt.name, got, tt.provider)
+		}
+		if got := UsesIPInfo(&tt.conf); got != tt.usesIPInfo {
+			t.Errorf("%s: UsesIPInfo() = %v, want %v", tt.name, got, tt.usesIPInfo)
+		}
+	}
+}
+
+func TestNewUnknownProvider(t *testing.T) {
+	if _, err := New(&config.Config{ISPProvider: "whois"}); err == nil {
+		t.Error("New() with unknown provider succeeded")
+	}
+}
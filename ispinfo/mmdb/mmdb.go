@@ -0,0 +1,212 @@
+package mmdb
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librespeed/speedtest/results"
+)
+
+const (
+	// database files are checked for changes this often
+	reloadInterval = 30 * time.Second
+)
+
+type cityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+		TimeZone  string   `maxminddb:"time_zone"`
+	} `maxminddb:"location"`
+	Postal struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"postal"`
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// file is a MaxMind DB file that is reloaded when it changes on disk
+type file struct {
+	path    string
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+}
+
+func openFile(path string) (*file, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	// the file is read into memory instead of mapped, so that it can be overwritten in place
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := maxminddb.FromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Loaded %s database from %s, built %s", reader.Metadata.DatabaseType, path,
+		time.Unix(int64(reader.Metadata.BuildEpoch), 0).UTC().Format("2006-01-02"))
+	return &file{path: path, reader: reader, modTime: fi.ModTime(), size: fi.Size()}, nil
+}
+
+// changed reports whether the file on disk differs from the last one seen
+func (f *file) changed() (os.FileInfo, bool) {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return nil, false
+	}
+	return fi, !fi.ModTime().Equal(f.modTime) || fi.Size() != f.size
+}
+
+// Database looks up IP addresses in local MaxMind-format city and ASN databases, such as
+// GeoLite2-City and GeoLite2-ASN. Either database may be omitted.
+type Database struct {
+	lock     sync.RWMutex
+	city     *file
+	asn      *file
+	onReload []func()
+}
+
+// Open loads the databases at the given paths, an empty path skips the database. The
+// files are watched for changes and reloaded in the background.
+func Open(cityPath, asnPath string) (*Database, error) {
+	d := &Database{}
+
+	if cityPath != "" {
+		f, err := openFile(cityPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open city database: %w", err)
+		}
+		d.city = f
+	}
+	if asnPath != "" {
+		f, err := openFile(asnPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open ASN database: %w", err)
+		}
+		d.asn = f
+	}
+
+	go d.watch()
+	return d, nil
+}
+
+// Lookup returns what the databases know about addr in the format of ipinfo.io. found is
+// false if neither database has an entry.
+func (d *Database) Lookup(addr string) (info results.IPInfoResponse, found bool, err error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return info, false, fmt.Errorf("invalid IP address: %s", addr)
+	}
+	info.IP = addr
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	if d.city != nil {
+		var record cityRecord
+		_, ok, err := d.city.reader.LookupNetwork(ip, &record)
+		if err != nil {
+			return info, false, err
+		}
+		if ok {
+			found = true
+			info.City = record.City.Names["en"]
+			if len(record.Subdivisions) > 0 {
+				info.Region = record.Subdivisions[0].Names["en"]
+			}
+			info.Country = record.Country.ISOCode
+			if record.Location.Latitude != nil && record.Location.Longitude != nil {
+				info.Location = strconv.FormatFloat(*record.Location.Latitude, 'f', 4, 64) + "," +
+					strconv.FormatFloat(*record.Location.Longitude, 'f', 4, 64)
+			}
+			info.Timezone = record.Location.TimeZone
+			info.Postal = record.Postal.Code
+		}
+	}
+
+	if d.asn != nil {
+		var record asnRecord
+		_, ok, err := d.asn.reader.LookupNetwork(ip, &record)
+		if err != nil {
+			return info, false, err
+		}
+		if ok && record.Number != 0 {
+			found = true
+			info.Organization = fmt.Sprintf("AS%d %s", record.Number, record.Organization)
+		}
+	}
+
+	return info, found, nil
+}
+
+// OnReload registers f to be called after a database file has been reloaded
+func (d *Database) OnReload(f func()) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.onReload = append(d.onReload, f)
+}
+
+func (d *Database) watch() {
+	for range time.Tick(reloadInterval) {
+		d.reload(&d.city)
+		d.reload(&d.asn)
+	}
+}
+
+// reload replaces f if the file on disk changed. A broken replacement is logged and the
+// old database is kept, so a partially copied file doesn't disable lookups.
+func (d *Database) reload(f **file) {
+	d.lock.RLock()
+	current := *f
+	d.lock.RUnlock()
+
+	if current == nil {
+		return
+	}
+	fi, changed := current.changed()
+	if !changed {
+		return
+	}
+
+	replacement, err := openFile(current.path)
+	if err != nil {
+		log.Errorf("Cannot reload %s, keeping the previous version: %s", current.path, err)
+		// retry only once the file changes again
+		d.lock.Lock()
+		current.modTime, current.size = fi.ModTime(), fi.Size()
+		d.lock.Unlock()
+		return
+	}
+
+	d.lock.Lock()
+	*f = replacement
+	callbacks := d.onReload
+	d.lock.Unlock()
+
+	for _, callback := range callbacks {
+		callback()
+	}
+}
@@ -0,0 +1,237 @@
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// encode writes v in the MaxMind DB data section format
+func encode(buf *bytes.Buffer, v interface{}) {
+	// control byte with the type in the upper three bits, types above 7 are extended.
+	// Sizes from 29 to 284 are stored in an extra byte.
+	control := func(typeNum, size int) {
+		extra := -1
+		if size >= 29 {
+			size, extra = 29, size-29
+		}
+		if typeNum > 7 {
+			buf.WriteByte(byte(size))
+			buf.WriteByte(byte(typeNum - 7))
+		} else {
+			buf.WriteByte(byte(typeNum<<5 | size))
+		}
+		if extra >= 0 {
+			buf.WriteByte(byte(extra))
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		control(2, len(v))
+		buf.WriteString(v)
+	case float64:
+		control(3, 8)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case uint16:
+		control(5, 2)
+		_ = binary.Write(buf, binary.BigEndian, v)
+	case uint32:
+		control(6, 4)
+		_ = binary.Write(buf, binary.BigEndian, v)
+	case uint64:
+		control(9, 8)
+		_ = binary.Write(buf, binary.BigEndian, v)
+	case map[string]interface{}:
+		control(7, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			encode(buf, k)
+			encode(buf, v[k])
+		}
+	case []interface{}:
+		control(11, len(v))
+		for _, e := range v {
+			encode(buf, e)
+		}
+	default:
+		panic("unsupported type")
+	}
+}
+
+// writeDatabase creates an IPv4 database in which every address of network maps to record
+func writeDatabase(t *testing.T, path, databaseType, network string, record map[string]interface{}) {
+	t.Helper()
+	_, prefix, err := net.ParseCIDR(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits, _ := prefix.Mask.Size()
+	ip := prefix.IP.To4()
+
+	// one node per prefix bit, the branch off the prefix points to the empty record
+	nodeCount := uint32(bits)
+	var buf bytes.Buffer
+	for i := 0; i < bits; i++ {
+		next := uint32(i + 1)
+		if i == bits-1 {
+			// pointer to the start of the data section
+			next = nodeCount + 16
+		}
+		left, right := next, nodeCount
+		if ip[i/8]>>(7-i%8)&1 == 1 {
+			left, right = nodeCount, next
+		}
+		buf.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+	}
+	buf.Write(make([]byte, 16))
+	encode(&buf, record)
+
+	buf.WriteString("\xab\xcd\xefMaxMind.com")
+	encode(&buf, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC).Unix()),
+		"database_type":               databaseType,
+		"description":                 map[string]interface{}{"en": "test database"},
+		"ip_version":                  uint16(4),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  nodeCount,
+		"record_size":                 uint16(24),
+	})
+
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func asnData(number uint32, org string) map[string]interface{} {
+	return map[string]interface{}{
+		"autonomous_system_number":       number,
+		"autonomous_system_organization": org,
+	}
+}
+
+var berlin = map[string]interface{}{
+	"city":         map[string]interface{}{"names": map[string]interface{}{"en": "Berlin", "de": "Berlin"}},
+	"subdivisions": []interface{}{map[string]interface{}{"names": map[string]interface{}{"en": "Land Berlin"}}},
+	"country":      map[string]interface{}{"iso_code": "DE"},
+	"location": map[string]interface{}{
+		"latitude":  52.52,
+		"longitude": 13.405,
+		"time_zone": "Europe/Berlin",
+	},
+	"postal": map[string]interface{}{"code": "10115"},
+}
+
+func TestLookup(t *testing.T) {
+	dir := t.TempDir()
+	cityPath, asnPath := filepath.Join(dir, "city.mmdb"), filepath.Join(dir, "asn.mmdb")
+	writeDatabase(t, cityPath, "GeoLite2-City", "192.0.2.0/24", berlin)
+	writeDatabase(t, asnPath, "GeoLite2-ASN", "192.0.0.0/16", asnData(64496, "Example ISP"))
+
+	d, err := Open(cityPath, asnPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, found, err := d.Lookup("192.0.2.10")
+	if err != nil || !found {
+		t.Fatalf("Lookup() = %v, %v", found, err)
+	}
+	want := []struct{ field, got, want string }{
+		{"ip", info.IP, "192.0.2.10"},
+		{"city", info.City, "Berlin"},
+		{"region", info.Region, "Land Berlin"},
+		{"country", info.Country, "DE"},
+		{"loc", info.Location, "52.5200,13.4050"},
+		{"timezone", info.Timezone, "Europe/Berlin"},
+		{"postal", info.Postal, "10115"},
+		{"org", info.Organization, "AS64496 Example ISP"},
+	}
+	for _, w := range want {
+		if w.got != w.want {
+			t.Errorf("%s = %q, want %q", w.field, w.got, w.want)
+		}
+	}
+
+	// only in the ASN database
+	info, found, err = d.Lookup("192.0.3.1")
+	if err != nil || !found || info.City != "" || info.Organization != "AS64496 Example ISP" {
+		t.Errorf("Lookup(192.0.3.1) = %+v, %v, %v", info, found, err)
+	}
+
+	if _, found, err := d.Lookup("198.51.100.1"); err != nil || found {
+		t.Errorf("Lookup(198.51.100.1) = %v, %v, want not found", found, err)
+	}
+	if _, _, err := d.Lookup("not an address"); err == nil {
+		t.Error("Lookup() of invalid address succeeded")
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "broken.mmdb")
+	if err := os.WriteFile(broken, []byte("not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(filepath.Join(dir, "missing.mmdb"), ""); err == nil {
+		t.Error("Open() of missing city database succeeded")
+	}
+	if _, err := Open("", broken); err == nil {
+		t.Error("Open() of broken ASN database succeeded")
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "asn.mmdb")
+	writeDatabase(t, path, "GeoLite2-ASN", "192.0.2.0/24", asnData(64496, "Example ISP"))
+
+	d, err := Open("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloads := 0
+	d.OnReload(func() { reloads++ })
+
+	org := func() string {
+		info, _, err := d.Lookup("192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Organization
+	}
+
+	// unchanged file
+	d.reload(&d.asn)
+	if reloads != 0 {
+		t.Errorf("%d reloads of unchanged file", reloads)
+	}
+
+	writeDatabase(t, path, "GeoLite2-ASN", "192.0.2.0/24", asnData(64497, "Other Example ISP"))
+	d.reload(&d.asn)
+	if reloads != 1 || org() != "AS64497 Other Example ISP" {
+		t.Errorf("after update: %d reloads, org %q", reloads, org())
+	}
+
+	// a broken replacement keeps the previous database
+	if err := os.WriteFile(path, []byte("partially copied"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d.reload(&d.asn)
+	d.reload(&d.asn)
+	if reloads != 1 || org() != "AS64497 Other Example ISP" {
+		t.Errorf("after broken update: %d reloads, org %q", reloads, org())
+	}
+}
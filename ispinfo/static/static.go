@@ -0,0 +1,80 @@
+package static
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/librespeed/speedtest/results"
+)
+
+type network struct {
+	prefix *net.IPNet
+	isp    string
+}
+
+// Provider maps address ranges to ISP names from a file
+type Provider struct {
+	// sorted from most to least specific, so that the first match is the longest prefix
+	networks []network
+}
+
+// Open reads a mapping file. Each line holds a CIDR range and the ISP name, separated by
+// whitespace, e.g. "192.0.2.0/24 Example ISP". Empty lines and lines starting with # are
+// ignored.
+func Open(path string) (*Provider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &Provider{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected a CIDR range and an ISP name", path, line)
+		}
+		_, prefix, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		p.networks = append(p.networks, network{prefix: prefix, isp: strings.Join(fields[1:], " ")})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(p.networks, func(i, j int) bool {
+		a, _ := p.networks[i].prefix.Mask.Size()
+		b, _ := p.networks[j].prefix.Mask.Size()
+		return a > b
+	})
+	return p, nil
+}
+
+func (p *Provider) Lookup(addr string) (results.IPInfoResponse, bool, error) {
+	ret := results.IPInfoResponse{IP: addr}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ret, false, fmt.Errorf("invalid IP address: %s", addr)
+	}
+
+	for _, n := range p.networks {
+		if n.prefix.Contains(ip) {
+			ret.Organization = n.isp
+			return ret, true, nil
+		}
+	}
+	return ret, false, nil
+}
@@ -0,0 +1,78 @@
+package static
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "isp.txt")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLookup(t *testing.T) {
+	p, err := Open(writeFile(t, `
+# office networks
+10.0.0.0/8       Example Corp
+10.20.0.0/16     Example Corp Lab
+
+192.0.2.0/24	Example ISP
+2001:db8::/32    Example ISP IPv6
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr  string
+		isp   string
+		found bool
+	}{
+		{"10.1.2.3", "Example Corp", true},
+		{"10.20.1.1", "Example Corp Lab", true},
+		{"::ffff:10.20.1.1", "Example Corp Lab", true},
+		{"192.0.2.200", "Example ISP", true},
+		{"2001:db8::1", "Example ISP IPv6", true},
+		{"198.51.100.1", "", false},
+	}
+	for _, tt := range tests {
+		info, found, err := p.Lookup(tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != tt.found || info.Organization != tt.isp || info.IP != tt.addr {
+			t.Errorf("Lookup(%s) = %+v, %v, want %q, %v", tt.addr, info, found, tt.isp, tt.found)
+		}
+	}
+
+	if _, _, err := p.Lookup("not an address"); err == nil {
+		t.Error("Lookup() of invalid address succeeded")
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"missing name", "192.0.2.0/24 Example ISP\n10.0.0.0/8\n", "isp.txt:2: expected a CIDR range and an ISP name"},
+		{"invalid range", "# comment\n192.0.2.0/33 Example ISP\n", "isp.txt:2: invalid CIDR address"},
+	}
+	for _, tt := range tests {
+		_, err := Open(writeFile(t, tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Open() error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("Open() of missing file succeeded")
+	}
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	_ "time/tzdata"
 
 	"github.com/librespeed/speedtest/config"
 	"github.com/librespeed/speedtest/database"
 	"github.com/librespeed/speedtest/results"
+	"github.com/librespeed/speedtest/sites"
 	"github.com/librespeed/speedtest/web"
 
 	_ "github.com/breml/rootcerts"
@@ -18,10 +24,39 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		runClient(os.Args[2:])
+		return
+	}
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %[1]s [-c config]\n       %[1]s client -server URL [options]\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 	conf := config.Load(*optConfig)
+	sites.Load(&conf)
+	web.InitISPInfo(&conf)
 	web.SetServerLocation(&conf)
 	results.Initialize(&conf)
 	database.SetDBInfo(&conf)
-	log.Fatal(web.ListenAndServe(&conf))
+
+	// the first signal starts a graceful shutdown, a second one exits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := web.ListenAndServe(ctx, &conf)
+	if closeErr := database.DB.Close(); closeErr != nil {
+		log.Errorf("Error closing database: %s", closeErr)
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Info("Shutdown complete")
 }
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "speedtest"
+
+	StreamDownload      = "download"
+	StreamUpload        = "upload"
+	StreamLatency       = "latency"
+	StreamLoadedLatency = "loaded_latency"
+
+	CacheHit         = "hit"
+	CacheNegativeHit = "negative_hit"
+	CacheMiss        = "miss"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by route and method, including streaming the response body.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"route", "method"})
+
+	GarbageBytesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "garbage_bytes_sent_total",
+		Help:      "Bytes of random data sent by the download test endpoint, by HTTP protocol version.",
+	}, []string{"protocol"})
+
+	EmptyBytesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "empty_bytes_received_total",
+		Help:      "Bytes received and discarded by the upload test endpoint, by HTTP protocol version.",
+	}, []string{"protocol"})
+
+	ActiveStreams = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_streams",
+		Help:      "Number of download, upload, latency and loaded latency test streams in progress.",
+	}, []string{"type"})
+
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Number of test streams refused or cut short by rate limiting, by scope and limit.",
+	}, []string{"scope", "limit"})
+
+	ISPCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "isp_cache_lookups_total",
+		Help:      "Number of ISP info cache lookups by result: hit, negative_hit (cached failure) or miss.",
+	}, []string{"result"})
+
+	ISPCacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "isp_cache_entries",
+		Help:      "Number of entries in the ISP info cache.",
+	})
+
+	TelemetryInsertErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telemetry_insert_errors_total",
+		Help:      "Number of telemetry records that could not be stored in the database.",
+	})
+
+	DatabaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "database_operation_duration_seconds",
+		Help:      "Duration of database operations by backend and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "operation"})
+
+	DatabaseErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "database_errors_total",
+		Help:      "Number of failed database operations by backend and operation.",
+	}, []string{"backend", "operation"})
+)
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware records request counts and latencies per chi route pattern, so that
+// cardinality stays bounded regardless of the requested paths
+func Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		requestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	}
+	return http.HandlerFunc(fn)
+}
+
+// ObserveDatabase records the duration and outcome of a database operation started at start
+func ObserveDatabase(backend, operation string, start time.Time, err error) {
+	DatabaseDuration.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		DatabaseErrors.WithLabelValues(backend, operation).Inc()
+	}
+}
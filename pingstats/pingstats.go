@@ -0,0 +1,37 @@
+package pingstats
+
+import (
+	"math"
+)
+
+// Compute calculates ping and jitter in milliseconds like the ping test of
+// speedtest_worker.js: ping is the lowest round trip time, jitter a weighted average of
+// the differences between consecutive round trips that gives spikes more weight. Zero
+// round trip times mark lost probes and are skipped.
+func Compute(rtts []float64) (ping, jitter float64) {
+	var previous float64
+	received := 0
+	for _, rtt := range rtts {
+		if rtt == 0 {
+			continue
+		}
+
+		received++
+		if received == 1 {
+			ping = rtt
+		} else {
+			ping = math.Min(ping, rtt)
+			d := math.Abs(rtt - previous)
+			switch {
+			case received == 2:
+				jitter = d
+			case d > jitter:
+				jitter = jitter*0.3 + d*0.7
+			default:
+				jitter = jitter*0.8 + d*0.2
+			}
+		}
+		previous = rtt
+	}
+	return ping, jitter
+}
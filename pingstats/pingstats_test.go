@@ -0,0 +1,31 @@
+package pingstats
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name   string
+		rtts   []float64
+		ping   float64
+		jitter float64
+	}{
+		{"empty", nil, 0, 0},
+		{"single", []float64{5}, 5, 0},
+		{"two", []float64{10, 30}, 10, 20},
+		{"smaller difference", []float64{10, 20, 15}, 10, 9},
+		{"larger difference", []float64{10, 20, 40}, 10, 17},
+		{"constant", []float64{7, 7, 7, 7}, 7, 0},
+		{"lowest last", []float64{30, 20, 10}, 10, 10},
+		{"lost probes are skipped", []float64{0, 10, 0, 30, 0}, 10, 20},
+		{"only lost probes", []float64{0, 0}, 0, 0},
+	}
+	for _, tt := range tests {
+		ping, jitter := Compute(tt.rtts)
+		if math.Abs(ping-tt.ping) > 1e-9 || math.Abs(jitter-tt.jitter) > 1e-9 {
+			t.Errorf("%s: Compute(%v) = %v, %v, want %v, %v", tt.name, tt.rtts, ping, jitter, tt.ping, tt.jitter)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	ScopeIP     = "ip"
+	ScopeGlobal = "global"
+
+	LimitRequests = "requests"
+	LimitStreams  = "streams"
+	LimitBytes    = "bytes"
+
+	cleanupInterval = time.Minute
+
+	// IPv6 clients usually get a whole /64, so they are limited per prefix
+	ipv6PrefixBits = 64
+)
+
+// Limits configures one scope. Zero values disable the respective limit.
+type Limits struct {
+	// Streams is the number of concurrent download and upload streams
+	Streams int
+	// BytesPerMinute is the traffic allowance, refilled continuously
+	BytesPerMinute int64
+	// RequestsPerSecond is the rate of new streams, with bursts of up to one second worth of requests
+	RequestsPerSecond float64
+}
+
+func (l Limits) enabled() bool {
+	return l.Streams > 0 || l.BytesPerMinute > 0 || l.RequestsPerSecond > 0
+}
+
+// Error reports an exceeded limit
+type Error struct {
+	Scope string
+	Limit string
+	// RetryAfter is an estimate of when the request would be accepted again
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s limit exceeded", e.Scope, e.Limit)
+}
+
+// bucket is a token bucket refilled at rate tokens per second up to burst tokens
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) refill(now time.Time, rate, burst float64) {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+}
+
+// wait returns the time until the bucket holds n tokens again
+func (b *bucket) wait(n, rate float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / rate * float64(time.Second))
+}
+
+type state struct {
+	limits   Limits
+	scope    string
+	streams  int
+	requests bucket
+	bytes    bucket
+	lastSeen time.Time
+}
+
+func (s *state) bytesRate() float64 {
+	return float64(s.limits.BytesPerMinute) / 60
+}
+
+// check returns an error if a new stream would exceed a limit
+func (s *state) check(now time.Time) *Error {
+	if s.limits.RequestsPerSecond > 0 {
+		s.requests.refill(now, s.limits.RequestsPerSecond, math.Max(1, s.limits.RequestsPerSecond))
+		if s.requests.tokens < 1 {
+			return &Error{Scope: s.scope, Limit: LimitRequests, RetryAfter: s.requests.wait(1, s.limits.RequestsPerSecond)}
+		}
+	}
+	if s.limits.Streams > 0 && s.streams >= s.limits.Streams {
+		return &Error{Scope: s.scope, Limit: LimitStreams, RetryAfter: time.Second}
+	}
+	if s.limits.BytesPerMinute > 0 {
+		s.bytes.refill(now, s.bytesRate(), float64(s.limits.BytesPerMinute))
+		if s.bytes.tokens <= 0 {
+			return &Error{Scope: s.scope, Limit: LimitBytes, RetryAfter: s.bytes.wait(1, s.bytesRate())}
+		}
+	}
+	return nil
+}
+
+func (s *state) open(now time.Time) {
+	if s.limits.RequestsPerSecond > 0 {
+		s.requests.tokens--
+	}
+	s.streams++
+	s.lastSeen = now
+}
+
+// transfer charges n bytes, which may overdraw the allowance by the last chunk
+func (s *state) transfer(now time.Time, n int64) *Error {
+	s.lastSeen = now
+	if s.limits.BytesPerMinute <= 0 {
+		return nil
+	}
+	s.bytes.refill(now, s.bytesRate(), float64(s.limits.BytesPerMinute))
+	s.bytes.tokens -= float64(n)
+	if s.bytes.tokens < 0 {
+		return &Error{Scope: s.scope, Limit: LimitBytes, RetryAfter: s.bytes.wait(1, s.bytesRate())}
+	}
+	return nil
+}
+
+// idle reports whether the state is back to its initial values, so that it can be dropped.
+// Buckets are full again after one second (requests) or one minute (bytes) at the latest.
+func (s *state) idle(now time.Time) bool {
+	return s.streams == 0 && now.Sub(s.lastSeen) > time.Minute
+}
+
+// Limiter enforces per client and global limits on test streams
+type Limiter struct {
+	lock    sync.Mutex
+	perIP   Limits
+	global  state
+	clients map[string]*state
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns a limiter, or nil if no limit is configured. A nil *Limiter accepts everything.
+func New(perIP, global Limits) *Limiter {
+	if !perIP.enabled() && !global.enabled() {
+		return nil
+	}
+
+	l := &Limiter{
+		perIP:   perIP,
+		global:  state{limits: global, scope: ScopeGlobal},
+		clients: make(map[string]*state),
+		stop:    make(chan struct{}),
+	}
+	if perIP.enabled() {
+		go l.cleanup()
+	}
+	return l
+}
+
+// Open starts a stream for the client at ip, or returns an *Error if a limit is exceeded
+func (l *Limiter) Open(ip string) (*Stream, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	now := time.Now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var client *state
+	if l.perIP.enabled() {
+		key := ClientKey(ip)
+		client = l.clients[key]
+		if client == nil {
+			client = &state{limits: l.perIP, scope: ScopeIP}
+			l.clients[key] = client
+		}
+		if err := client.check(now); err != nil {
+			client.lastSeen = now
+			return nil, err
+		}
+	}
+	if err := l.global.check(now); err != nil {
+		return nil, err
+	}
+
+	if client != nil {
+		client.open(now)
+	}
+	l.global.open(now)
+	return &Stream{limiter: l, client: client}, nil
+}
+
+// Close stops forgetting idle clients in the background. A nil *Limiter may be closed.
+func (l *Limiter) Close() {
+	if l == nil {
+		return
+	}
+	l.closeOnce.Do(func() {
+		close(l.stop)
+	})
+}
+
+func (l *Limiter) cleanup() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			l.forgetIdle(now)
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// forgetIdle drops the clients that are idle at now
+func (l *Limiter) forgetIdle(now time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for key, client := range l.clients {
+		if client.idle(now) {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// Stream is a download or upload stream admitted by a Limiter. A nil *Stream is unlimited.
+type Stream struct {
+	limiter *Limiter
+	client  *state
+	closed  bool
+}
+
+// Transfer charges n transferred bytes, returning an *Error once the allowance is used up
+func (s *Stream) Transfer(n int64) error {
+	if s == nil {
+		return nil
+	}
+
+	now := time.Now()
+
+	s.limiter.lock.Lock()
+	defer s.limiter.lock.Unlock()
+
+	var err *Error
+	if s.client != nil {
+		err = s.client.transfer(now, n)
+	}
+	if globalErr := s.limiter.global.transfer(now, n); err == nil && globalErr != nil {
+		err = globalErr
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close ends the stream
+func (s *Stream) Close() {
+	if s == nil {
+		return
+	}
+
+	s.limiter.lock.Lock()
+	defer s.limiter.lock.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.client != nil {
+		s.client.streams--
+	}
+	s.limiter.global.streams--
+}
+
+// ClientKey returns the address for IPv4 clients and the /64 prefix for IPv6 clients.
+// IPv4-mapped IPv6 addresses, as seen on dual-stack listeners, count as IPv4.
+func ClientKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap().WithZone("")
+	if addr.Is4() {
+		return addr.String()
+	}
+	return netip.PrefixFrom(addr, ipv6PrefixBits).Masked().String()
+}
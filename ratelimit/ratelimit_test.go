@@ -0,0 +1,262 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBucketRefill(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name    string
+		tokens  float64
+		last    time.Time
+		elapsed time.Duration
+		rate    float64
+		burst   float64
+		want    float64
+	}{
+		{"new bucket is full", 0, time.Time{}, 0, 2, 5, 5},
+		{"refills at rate", 1, start, 500 * time.Millisecond, 2, 5, 2},
+		{"capped at burst", 4, start, 10 * time.Second, 2, 5, 5},
+		{"no time passed", 3, start, 0, 2, 5, 3},
+		{"overdrawn", -10, start, 2 * time.Second, 2, 5, -6},
+		{"fractional rate", 0, start, 3 * time.Second, 0.5, 1, 1},
+	}
+	for _, tt := range tests {
+		b := bucket{tokens: tt.tokens, last: tt.last}
+		now := start.Add(tt.elapsed)
+		b.refill(now, tt.rate, tt.burst)
+		if b.tokens != tt.want {
+			t.Errorf("%s: tokens = %v, want %v", tt.name, b.tokens, tt.want)
+		}
+		if !b.last.Equal(now) {
+			t.Errorf("%s: last = %v, want %v", tt.name, b.last, now)
+		}
+	}
+}
+
+func TestBucketWait(t *testing.T) {
+	tests := []struct {
+		tokens float64
+		n      float64
+		rate   float64
+		want   time.Duration
+	}{
+		{1, 1, 2, 0},
+		{5, 1, 2, 0},
+		{0, 1, 2, 500 * time.Millisecond},
+		{-3, 1, 2, 2 * time.Second},
+		{0.5, 1, 0.5, time.Second},
+	}
+	for _, tt := range tests {
+		b := bucket{tokens: tt.tokens}
+		if got := b.wait(tt.n, tt.rate); got != tt.want {
+			t.Errorf("wait(%v, %v) with %v tokens = %v, want %v", tt.n, tt.rate, tt.tokens, got, tt.want)
+		}
+	}
+}
+
+func TestStateRequests(t *testing.T) {
+	type step struct {
+		at time.Duration
+		ok bool
+	}
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name  string
+		rate  float64
+		steps []step
+	}{
+		{"burst of one second", 3, []step{{0, true}, {0, true}, {0, true}, {0, false}, {400 * time.Millisecond, true}, {400 * time.Millisecond, false}}},
+		{"slow rate allows a single request", 0.5, []step{{0, true}, {time.Second, false}, {2 * time.Second, true}}},
+	}
+	for _, tt := range tests {
+		s := &state{limits: Limits{RequestsPerSecond: tt.rate}, scope: ScopeIP}
+		for i, step := range tt.steps {
+			now := start.Add(step.at)
+			err := s.check(now)
+			if (err == nil) != step.ok {
+				t.Fatalf("%s: step %d: check() = %v, want ok %v", tt.name, i, err, step.ok)
+			}
+			if err != nil {
+				if err.Limit != LimitRequests || err.RetryAfter <= 0 {
+					t.Errorf("%s: step %d: error %+v", tt.name, i, err)
+				}
+				continue
+			}
+			s.open(now)
+			s.streams--
+		}
+	}
+}
+
+func TestStateStreamsAndBytes(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := &state{limits: Limits{Streams: 2, BytesPerMinute: 600}, scope: ScopeGlobal}
+
+	for i := 0; i < 2; i++ {
+		if err := s.check(now); err != nil {
+			t.Fatalf("stream %d: %s", i, err)
+		}
+		s.open(now)
+	}
+	if err := s.check(now); err == nil || err.Limit != LimitStreams {
+		t.Fatalf("third stream: %v, want streams limit", err)
+	}
+	s.streams--
+
+	if err := s.transfer(now, 500); err != nil {
+		t.Fatalf("transfer within allowance: %s", err)
+	}
+	// the last chunk may overdraw the allowance
+	err := s.transfer(now, 200)
+	if err == nil || err.Limit != LimitBytes || err.Scope != ScopeGlobal {
+		t.Fatalf("overdrawing transfer: %v, want bytes limit", err)
+	}
+	if want := 10100 * time.Millisecond; err.RetryAfter != want {
+		t.Errorf("RetryAfter = %v, want %v", err.RetryAfter, want)
+	}
+	if err := s.check(now.Add(5 * time.Second)); err == nil || err.Limit != LimitBytes {
+		t.Errorf("check while overdrawn: %v, want bytes limit", err)
+	}
+	if err := s.check(now.Add(11 * time.Second)); err != nil {
+		t.Errorf("check after refill: %s", err)
+	}
+}
+
+func TestStateIdle(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		streams  int
+		lastSeen time.Duration
+		want     bool
+	}{
+		{0, 2 * time.Minute, true},
+		{0, 30 * time.Second, false},
+		{1, 2 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		s := &state{streams: tt.streams, lastSeen: now.Add(-tt.lastSeen)}
+		if got := s.idle(now); got != tt.want {
+			t.Errorf("idle() with %d streams, last seen %v ago = %v, want %v", tt.streams, tt.lastSeen, got, tt.want)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	if New(Limits{}, Limits{}) != nil {
+		t.Error("limiter without limits is not nil")
+	}
+	var unlimited *Limiter
+	if s, err := unlimited.Open("192.0.2.1"); err != nil || s.Transfer(1<<30) != nil {
+		t.Error("nil limiter refused a stream")
+	}
+
+	l := New(Limits{Streams: 1}, Limits{Streams: 3})
+	a, err := l.Open("192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var limitErr *Error
+	if _, err := l.Open("192.0.2.1"); !errors.As(err, &limitErr) || limitErr.Scope != ScopeIP {
+		t.Errorf("second stream of the same client: %v, want per IP limit", err)
+	}
+	// addresses of the same /64 share the limit
+	if _, err := l.Open("2001:db8::1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Open("2001:db8::2"); !errors.As(err, &limitErr) || limitErr.Scope != ScopeIP {
+		t.Errorf("second stream of the same /64: %v, want per IP limit", err)
+	}
+	if _, err := l.Open("192.0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Open("192.0.2.3"); !errors.As(err, &limitErr) || limitErr.Scope != ScopeGlobal {
+		t.Errorf("fourth stream: %v, want global limit", err)
+	}
+
+	a.Close()
+	a.Close()
+	if _, err := l.Open("192.0.2.1"); err != nil {
+		t.Errorf("stream after close: %s", err)
+	}
+	if _, err := l.Open("192.0.2.3"); err == nil {
+		t.Error("closing a stream twice released two streams")
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.1", "192.0.2.1"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2::", "2001:db8:1:2::/64"},
+		// dual-stack listeners see IPv4 clients as IPv4-mapped addresses
+		{"::ffff:192.0.2.1", "192.0.2.1"},
+		{"fe80::1%eth0", "fe80::/64"},
+		{"not an address", "not an address"},
+	}
+	for _, tt := range tests {
+		if got := ClientKey(tt.ip); got != tt.want {
+			t.Errorf("ClientKey(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestLimiterSharesMappedAddresses(t *testing.T) {
+	l := New(Limits{Streams: 1}, Limits{})
+	defer l.Close()
+
+	if _, err := l.Open("192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Open("::ffff:192.0.2.1"); err == nil {
+		t.Error("IPv4-mapped address got a separate budget")
+	}
+}
+
+func TestLimiterForgetIdle(t *testing.T) {
+	l := New(Limits{Streams: 1}, Limits{})
+	defer l.Close()
+
+	s, err := l.Open("192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Open("192.0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	l.forgetIdle(time.Now().Add(2 * time.Minute))
+	if _, ok := l.clients["192.0.2.1"]; ok {
+		t.Error("idle client was not forgotten")
+	}
+	if _, ok := l.clients["192.0.2.2"]; !ok {
+		t.Error("client with an open stream was forgotten")
+	}
+}
+
+func TestLimiterClose(t *testing.T) {
+	l := New(Limits{Streams: 1}, Limits{})
+	done := make(chan struct{})
+	go func() {
+		l.cleanup()
+		close(done)
+	}()
+
+	l.Close()
+	l.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("cleanup did not stop after Close")
+	}
+
+	var unlimited *Limiter
+	unlimited.Close()
+}
@@ -0,0 +1,232 @@
+package results
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librespeed/speedtest/config"
+	"github.com/librespeed/speedtest/database"
+	"github.com/librespeed/speedtest/database/schema"
+)
+
+var (
+	exportColumns = []string{"timestamp", "id", "ip", "ispinfo", "extra", "ua", "lang", "dl", "ul", "ping", "jitter", "log", "server_dl", "server_ul", "flags", "site", "server_ping", "server_jitter", "packet_loss",
+		"loaded_ping_dl", "loaded_ping_ul", "loaded_packet_loss", "bufferbloat", "loaded_latency"}
+)
+
+// ExportRecord is the representation of a test result in NDJSON exports
+type ExportRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	UUID      string    `json:"id"`
+	IPAddress string    `json:"ip"`
+	ISPInfo   string    `json:"ispinfo"`
+	Extra     string    `json:"extra"`
+	UserAgent string    `json:"ua"`
+	Language  string    `json:"lang"`
+	Download  float64   `json:"dl"`
+	Upload    float64   `json:"ul"`
+	Ping      float64   `json:"ping"`
+	Jitter    float64   `json:"jitter"`
+	Log       string    `json:"log"`
+
+	ServerDownload float64 `json:"server_dl"`
+	ServerUpload   float64 `json:"server_ul"`
+	Flags          string  `json:"flags"`
+	Site           string  `json:"site"`
+	ServerPing     float64 `json:"server_ping"`
+	ServerJitter   float64 `json:"server_jitter"`
+	PacketLoss     float64 `json:"packet_loss"`
+
+	LoadedPingDownload float64         `json:"loaded_ping_dl"`
+	LoadedPingUpload   float64         `json:"loaded_ping_ul"`
+	LoadedPacketLoss   float64         `json:"loaded_packet_loss"`
+	Bufferbloat        string          `json:"bufferbloat"`
+	LoadedLatency      json.RawMessage `json:"loaded_latency"`
+}
+
+type recordWriter interface {
+	Write(*schema.TelemetryData) error
+	Flush() error
+}
+
+type csvRecordWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvRecordWriter) Write(record *schema.TelemetryData) error {
+	return c.w.Write([]string{
+		record.Timestamp.UTC().Format(time.RFC3339),
+		record.UUID,
+		csvCell(record.IPAddress),
+		csvCell(record.ISPInfo),
+		csvCell(record.Extra),
+		csvCell(record.UserAgent),
+		csvCell(record.Language),
+		strconv.FormatFloat(record.Download, 'f', -1, 64),
+		strconv.FormatFloat(record.Upload, 'f', -1, 64),
+		strconv.FormatFloat(record.Ping, 'f', -1, 64),
+		strconv.FormatFloat(record.Jitter, 'f', -1, 64),
+		csvCell(record.Log),
+		strconv.FormatFloat(record.ServerDownload, 'f', -1, 64),
+		strconv.FormatFloat(record.ServerUpload, 'f', -1, 64),
+		record.Flags,
+		csvCell(record.Site),
+		strconv.FormatFloat(record.ServerPing, 'f', -1, 64),
+		strconv.FormatFloat(record.ServerJitter, 'f', -1, 64),
+		strconv.FormatFloat(record.PacketLoss, 'f', -1, 64),
+		strconv.FormatFloat(record.LoadedPingDownload, 'f', -1, 64),
+		strconv.FormatFloat(record.LoadedPingUpload, 'f', -1, 64),
+		strconv.FormatFloat(record.LoadedPacketLoss, 'f', -1, 64),
+		record.Bufferbloat,
+		csvCell(record.LoadedLatency),
+	})
+}
+
+// csvCell escapes client supplied values that spreadsheets would evaluate as formulas
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (c *csvRecordWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonRecordWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonRecordWriter) Write(record *schema.TelemetryData) error {
+	return n.enc.Encode(ExportRecord{
+		Timestamp: record.Timestamp,
+		UUID:      record.UUID,
+		IPAddress: record.IPAddress,
+		ISPInfo:   record.ISPInfo,
+		Extra:     record.Extra,
+		UserAgent: record.UserAgent,
+		Language:  record.Language,
+		Download:  record.Download,
+		Upload:    record.Upload,
+		Ping:      record.Ping,
+		Jitter:    record.Jitter,
+		Log:       record.Log,
+
+		ServerDownload: record.ServerDownload,
+		ServerUpload:   record.ServerUpload,
+		Flags:          record.Flags,
+		Site:           record.Site,
+		ServerPing:     record.ServerPing,
+		ServerJitter:   record.ServerJitter,
+		PacketLoss:     record.PacketLoss,
+
+		LoadedPingDownload: record.LoadedPingDownload,
+		LoadedPingUpload:   record.LoadedPingUpload,
+		LoadedPacketLoss:   record.LoadedPacketLoss,
+		Bufferbloat:        record.Bufferbloat,
+		LoadedLatency:      loadedLatencyJSON(record.LoadedLatency),
+	})
+}
+
+// loadedLatencyJSON embeds the stored probes as an array, or null if there are none
+func loadedLatencyJSON(samples string) json.RawMessage {
+	if samples == "" || !json.Valid([]byte(samples)) {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(samples)
+}
+
+func (n *ndjsonRecordWriter) Flush() error {
+	return nil
+}
+
+// Export streams all results matching the stats page filters as CSV (format=csv) or
+// newline-delimited JSON (format=ndjson)
+func Export(w http.ResponseWriter, r *http.Request) {
+	conf := config.LoadedConfig()
+	if conf.DatabaseType == "none" {
+		http.Error(w, "Statistics are disabled", http.StatusNotFound)
+		return
+	}
+
+	if conf.StatsPassword == "PASSWORD" {
+		http.Error(w, "Please set statistics_password in settings.toml to enable access", http.StatusForbidden)
+		return
+	}
+
+	if !authenticated(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="LibreSpeed stats"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	q, err := queryFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Limit = schema.MaxPageSize
+
+	var rw recordWriter
+	switch r.FormValue("format") {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=speedtest-results.csv")
+		cw := csv.NewWriter(w)
+		if err := cw.Write(exportColumns); err != nil {
+			log.Errorf("Error writing export to client: %s", err)
+			return
+		}
+		rw = &csvRecordWriter{w: cw}
+	case "ndjson":
+		rw = &ndjsonRecordWriter{enc: json.NewEncoder(w)}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", "attachment; filename=speedtest-results.ndjson")
+	default:
+		http.Error(w, "unsupported format: "+r.FormValue("format"), http.StatusBadRequest)
+		return
+	}
+
+	if err := exportRecords(w, rw, q); err != nil {
+		log.Errorf("Error exporting results: %s", err)
+	}
+}
+
+// exportRecords fetches and writes the results page by page, so that exports don't have
+// to fit in memory. Errors after the first page can only be reported by cutting the
+// response short.
+func exportRecords(w io.Writer, rw recordWriter, q schema.Query) error {
+	flusher, _ := w.(http.Flusher)
+	for {
+		page, err := database.DB.Query(q)
+		if err != nil {
+			return err
+		}
+
+		for i := range page.Records {
+			if err := rw.Write(&page.Records[i]); err != nil {
+				return err
+			}
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if page.NextCursor == "" {
+			return nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
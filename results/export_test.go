@@ -0,0 +1,75 @@
+package results
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest/database/schema"
+)
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"Mozilla/5.0", "Mozilla/5.0"},
+		{`=HYPERLINK("http://example.com","x")`, `'=HYPERLINK("http://example.com","x")`},
+		{"+cmd|' /C calc'!A0", "'+cmd|' /C calc'!A0"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1:A2)", "'@SUM(A1:A2)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"a=1", "a=1"},
+		{`{"ip":"1.2.3.4"}`, `{"ip":"1.2.3.4"}`},
+	}
+	for _, tt := range tests {
+		if got := csvCell(tt.in); got != tt.want {
+			t.Errorf("csvCell(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCSVRecordWriterEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	w := &csvRecordWriter{w: csv.NewWriter(&buf)}
+	err := w.Write(&schema.TelemetryData{
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		UUID:      "01HX",
+		IPAddress: "192.0.2.1",
+		ISPInfo:   "@evil",
+		Extra:     "=1+1",
+		UserAgent: "+cmd",
+		Language:  "en",
+		Log:       "-log",
+		Download:  -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := csv.NewReader(&buf).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(row) != len(exportColumns) {
+		t.Fatalf("got %d columns, want %d", len(row), len(exportColumns))
+	}
+	want := map[string]string{
+		"ispinfo": "'@evil",
+		"extra":   "'=1+1",
+		"ua":      "'+cmd",
+		"lang":    "en",
+		"log":     "'-log",
+		"dl":      "-1",
+	}
+	for i, column := range exportColumns {
+		if w, ok := want[column]; ok && row[i] != w {
+			t.Errorf("column %s = %q, want %q", column, row[i], w)
+		}
+	}
+}
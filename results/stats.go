@@ -1,8 +1,14 @@
 package results
 
 import (
+	"crypto/subtle"
+	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/render"
 	log "github.com/sirupsen/logrus"
@@ -18,24 +24,58 @@ type StatsData struct {
 	NoPassword bool
 	LoggedIn   bool
 	Data       []schema.TelemetryData
+	Filter     StatsFilter
+	NextPage   string
+	Filtered   bool
+	ExportCSV  string
+	ExportJSON string
 }
 
+// StatsFilter holds the search form values, to fill the form again on the result page
+type StatsFilter struct {
+	From      string
+	To        string
+	IP        string
+	ISP       string
+	UserAgent string
+	Order     string
+}
+
+var (
+	filterTimeFormats = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"}
+)
+
 var (
 	key   = []byte(securecookie.GenerateRandomKey(32))
 	store = sessions.NewCookieStore(key)
-	conf = config.LoadedConfig()
 )
 
-func init() {
+func initSessionStore(conf *config.Config) {
+	// the session is shared between the stats page and the stats API
 	store.Options = &sessions.Options{
-		Path:     conf.BaseURL+"/stats",
+		Path:     conf.BaseURL + "/",
 		MaxAge:   3600 * 1, // 1 hour
 		HttpOnly: true,
 		SameSite: http.SameSiteStrictMode,
 	}
 }
 
+// authenticated reports whether the request belongs to a logged in stats session, or
+// carries the statistics password as HTTP basic auth password for API clients
+func authenticated(r *http.Request) bool {
+	conf := config.LoadedConfig()
+	if _, password, ok := r.BasicAuth(); ok {
+		return subtle.ConstantTimeCompare([]byte(password), []byte(conf.StatsPassword)) == 1
+	}
+
+	session, _ := store.Get(r, "logged")
+	auth, ok := session.Values["authenticated"].(bool)
+	return auth && ok
+}
+
 func Stats(w http.ResponseWriter, r *http.Request) {
+	conf := config.LoadedConfig()
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	t, err := template.New("template").Parse(htmlTemplate)
 	if err != nil {
@@ -58,9 +98,8 @@ func Stats(w http.ResponseWriter, r *http.Request) {
 	if !data.NoPassword {
 		op := r.FormValue("op")
 		session, _ := store.Get(r, "logged")
-		auth, ok := session.Values["authenticated"].(bool)
 
-		if auth && ok {
+		if authenticated(r) {
 			if op == "logout" {
 				session.Values["authenticated"] = false
 				session.Options.MaxAge = -1
@@ -68,18 +107,39 @@ func Stats(w http.ResponseWriter, r *http.Request) {
 				http.Redirect(w, r, conf.BaseURL+"/stats", http.StatusTemporaryRedirect)
 			} else {
 				data.LoggedIn = true
+				data.Filtered, data.ExportCSV, data.ExportJSON = exportLinks(r)
 
 				id := r.FormValue("id")
-				switch id {
-				case "L100":
-					stats, err := database.DB.FetchLast100()
+				switch {
+				case op == "search" || id == "L100":
+					q, err := queryFromRequest(r)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					page, err := database.DB.Query(q)
 					if err != nil {
 						log.Errorf("Error fetching data from database: %s", err)
 						w.WriteHeader(http.StatusInternalServerError)
 						return
 					}
-					data.Data = stats
-				case "":
+					data.Data = page.Records
+					data.Filter = StatsFilter{
+						From:      r.FormValue("from"),
+						To:        r.FormValue("to"),
+						IP:        q.IPAddress,
+						ISP:       q.ISP,
+						UserAgent: q.UserAgent,
+						Order:     r.FormValue("order"),
+					}
+					if page.NextCursor != "" {
+						params := r.URL.Query()
+						params.Set("op", "search")
+						params.Del("id")
+						params.Set("cursor", page.NextCursor)
+						data.NextPage = "stats?" + params.Encode()
+					}
+				case id == "":
 				default:
 					stat, err := database.DB.FetchByUUID(id)
 					if err != nil {
@@ -111,6 +171,78 @@ func Stats(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// exportLinks returns the CSV and NDJSON export URLs for the current search filters
+func exportLinks(r *http.Request) (filtered bool, csvURL, ndjsonURL string) {
+	params := url.Values{}
+	for _, name := range []string{"from", "to", "ip", "isp", "ua", "order"} {
+		if v := r.FormValue(name); v != "" {
+			params.Set(name, v)
+			if name != "order" {
+				filtered = true
+			}
+		}
+	}
+
+	params.Set("format", "csv")
+	csvURL = "stats/export?" + params.Encode()
+	params.Set("format", "ndjson")
+	ndjsonURL = "stats/export?" + params.Encode()
+	return
+}
+
+// queryFromRequest builds a database query from the search form parameters
+func queryFromRequest(r *http.Request) (schema.Query, error) {
+	var q schema.Query
+	var err error
+
+	if q.From, err = parseFilterTime(r.FormValue("from")); err != nil {
+		return q, fmt.Errorf("invalid from time: %s", err)
+	}
+	if q.To, err = parseFilterTime(r.FormValue("to")); err != nil {
+		return q, fmt.Errorf("invalid to time: %s", err)
+	}
+
+	q.IPAddress = strings.TrimSpace(r.FormValue("ip"))
+	q.ISP = strings.TrimSpace(r.FormValue("isp"))
+	q.UserAgent = strings.TrimSpace(r.FormValue("ua"))
+	q.Cursor = r.FormValue("cursor")
+
+	switch r.FormValue("order") {
+	case "", "desc":
+		q.Order = schema.NewestFirst
+	case "asc":
+		q.Order = schema.OldestFirst
+	default:
+		return q, fmt.Errorf("invalid order: %s", r.FormValue("order"))
+	}
+
+	if limit := r.FormValue("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil {
+			return q, fmt.Errorf("invalid limit: %s", limit)
+		}
+	}
+
+	return q, nil
+}
+
+// parseFilterTime accepts RFC 3339 timestamps as well as the values of HTML date and
+// datetime-local inputs, which are interpreted as UTC
+func parseFilterTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	var err error
+	for _, layout := range filterTimeFormats {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
 const htmlTemplate = `<!DOCTYPE html>
 <html>
 <head>
@@ -169,23 +301,49 @@ const htmlTemplate = `<!DOCTYPE html>
 		<input type="hidden" name="op" value="id" />
 		<input type="text" name="id" id="id" placeholder="Test ID" value=""/>
 		<input type="submit" value="Find" />
-		<input type="submit" onclick="document.getElementById('id').value='L100'" value="Show last 100 tests" />
+	</form>
+	<form action="stats" method="GET">
+		<h3>Browse test results</h3>
+		<input type="hidden" name="op" value="search" />
+		<label>From (UTC) <input type="datetime-local" name="from" value="{{ .Filter.From }}"/></label>
+		<label>To (UTC) <input type="datetime-local" name="to" value="{{ .Filter.To }}"/></label>
+		<input type="text" name="ip" placeholder="IP address" value="{{ .Filter.IP }}"/>
+		<input type="text" name="isp" placeholder="ISP" value="{{ .Filter.ISP }}"/>
+		<input type="text" name="ua" placeholder="User agent" value="{{ .Filter.UserAgent }}"/>
+		<select name="order">
+			<option value="desc">Newest first</option>
+			<option value="asc"{{ if eq .Filter.Order "asc" }} selected{{ end }}>Oldest first</option>
+		</select>
+		<input type="submit" value="Search" />
 	</form>
 
+	<p>
+		Export {{ if .Filtered }}matching{{ else }}all{{ end }} results:
+		<a href="{{ .ExportCSV }}">CSV</a>
+		<a href="{{ .ExportJSON }}">NDJSON</a>
+	</p>
+
 	{{ range $i, $v := .Data }}
 	<table>
 		<tr><th>Test ID</th><td>{{ $v.UUID }}</td></tr>
 		<tr><th>Date and time</th><td>{{ $v.Timestamp }}</td></tr>
 		<tr><th>IP and ISP Info</th><td>{{ $v.IPAddress }}<br/>{{ $v.ISPInfo }}</td></tr>
+		{{ if $v.Site }}<tr><th>Site</th><td>{{ $v.Site }}</td></tr>{{ end }}
 		<tr><th>User agent and locale</th><td>{{ $v.UserAgent }}<br/>{{ $v.Language }}</td></tr>
-		<tr><th>Download speed</th><td>{{ $v.Download }}</td></tr>
-		<tr><th>Upload speed</th><td>{{ $v.Upload }}</td></tr>
-		<tr><th>Ping</th><td>{{ $v.Ping }}</td></tr>
-		<tr><th>Jitter</th><td>{{ $v.Jitter }}</td></tr>
+		<tr><th>Download speed</th><td>{{ printf "%.2f" $v.Download }} Mbit/s</td></tr>
+		<tr><th>Upload speed</th><td>{{ printf "%.2f" $v.Upload }} Mbit/s</td></tr>
+		<tr><th>Ping</th><td>{{ printf "%.2f" $v.Ping }} ms</td></tr>
+		<tr><th>Jitter</th><td>{{ printf "%.2f" $v.Jitter }} ms</td></tr>
+		<tr><th>Server measured</th><td>Download {{ printf "%.2f" $v.ServerDownload }} Mbit/s, upload {{ printf "%.2f" $v.ServerUpload }} Mbit/s{{ if $v.ServerPing }}, ping {{ printf "%.2f" $v.ServerPing }} ms, jitter {{ printf "%.2f" $v.ServerJitter }} ms, packet loss {{ printf "%.1f" $v.PacketLoss }}%{{ end }}</td></tr>
+		{{ if or $v.LoadedPingDownload $v.LoadedPingUpload }}<tr><th>Latency under load</th><td>Download {{ printf "%.2f" $v.LoadedPingDownload }} ms, upload {{ printf "%.2f" $v.LoadedPingUpload }} ms, packet loss {{ printf "%.1f" $v.LoadedPacketLoss }}%{{ if $v.Bufferbloat }}, bufferbloat grade {{ $v.Bufferbloat }}{{ end }}</td></tr>{{ end }}
+		<tr><th>Flags</th><td>{{ $v.Flags }}</td></tr>
 		<tr><th>Log</th><td>{{ $v.Log }}</td></tr>
 		<tr><th>Extra info</th><td>{{ $v.Extra }}</td></tr>
 	</table>
 	{{ end }}
+	{{ if .NextPage }}
+	<a href="{{ .NextPage }}">Next page</a>
+	{{ end }}
 {{ else }}
 	<form action="stats?op=login" method="POST">
 		<h3>Login</h3>
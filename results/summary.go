@@ -0,0 +1,68 @@
+package results
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librespeed/speedtest/config"
+	"github.com/librespeed/speedtest/database"
+	"github.com/librespeed/speedtest/database/schema"
+)
+
+type SummaryResponse struct {
+	Period  schema.Period      `json:"period"`
+	GroupBy schema.GroupBy     `json:"groupBy,omitempty"`
+	Results []schema.Aggregate `json:"results"`
+}
+
+// StatsSummary returns per period statistics of the stored results as JSON. It accepts
+// the filters of the stats page, plus period (hour, day or week) and group (isp or country).
+func StatsSummary(w http.ResponseWriter, r *http.Request) {
+	conf := config.LoadedConfig()
+	if conf.DatabaseType == "none" {
+		http.Error(w, "Statistics are disabled", http.StatusNotFound)
+		return
+	}
+
+	if conf.StatsPassword == "PASSWORD" {
+		http.Error(w, "Please set statistics_password in settings.toml to enable access", http.StatusForbidden)
+		return
+	}
+
+	if !authenticated(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="LibreSpeed stats"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	q, err := queryFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	aq := schema.AggregateQuery{
+		Query:   q,
+		Period:  schema.Period(r.FormValue("period")),
+		GroupBy: schema.GroupBy(r.FormValue("group")),
+	}
+	if err := aq.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	aggregates, err := database.DB.Aggregate(aq)
+	if err != nil {
+		log.Errorf("Error aggregating data from database: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, SummaryResponse{
+		Period:  aq.Period,
+		GroupBy: aq.GroupBy,
+		Results: aggregates,
+	})
+}
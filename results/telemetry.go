@@ -3,14 +3,17 @@ package results
 import (
 	_ "embed"
 	"encoding/json"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/png"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,6 +21,9 @@ import (
 	"github.com/librespeed/speedtest/config"
 	"github.com/librespeed/speedtest/database"
 	"github.com/librespeed/speedtest/database/schema"
+	"github.com/librespeed/speedtest/metrics"
+	"github.com/librespeed/speedtest/session"
+	"github.com/librespeed/speedtest/sites"
 
 	"github.com/golang/freetype"
 	"github.com/golang/freetype/truetype"
@@ -35,6 +41,11 @@ const (
 	labelJitter   = "Jitter"
 	labelDownload = "Download"
 	labelUpload   = "Upload"
+
+	flagDownloadNotMeasured = "download_not_measured"
+	flagDownloadImplausible = "download_implausible"
+	flagUploadNotMeasured   = "upload_not_measured"
+	flagUploadImplausible   = "upload_implausible"
 )
 
 //go:embed fonts/NotoSansDisplay-Medium.ttf
@@ -71,6 +82,39 @@ var (
 type Result struct {
 	ProcessedString string         `json:"processedString"`
 	RawISPInfo      IPInfoResponse `json:"rawIspInfo"`
+	Site            string         `json:"site,omitempty"`
+	Address         AddressInfo    `json:"address"`
+}
+
+// address classes of AddressInfo
+const (
+	AddressPublic        = "public"
+	AddressPrivate       = "private"
+	AddressCGNAT         = "cgnat"
+	AddressLoopback      = "loopback"
+	AddressLinkLocal     = "link_local"
+	AddressUniqueLocal   = "unique_local"
+	AddressUnspecified   = "unspecified"
+	AddressMulticast     = "multicast"
+	AddressBroadcast     = "broadcast"
+	AddressDocumentation = "documentation"
+	AddressBenchmarking  = "benchmarking"
+	AddressReserved      = "reserved"
+	AddressNAT64         = "nat64"
+	AddressTeredo        = "teredo"
+	Address6to4          = "6to4"
+)
+
+// AddressInfo classifies the client address according to the IANA special-purpose
+// address registries. Range is the matching special-purpose prefix, empty for public
+// addresses. Global addresses are routable on the internet. EmbeddedIPv4 is the IPv4
+// address carried by NAT64, Teredo and 6to4 addresses.
+type AddressInfo struct {
+	Version      int    `json:"version"`
+	Class        string `json:"class"`
+	Range        string `json:"range,omitempty"`
+	Global       bool   `json:"global"`
+	EmbeddedIPv4 string `json:"embeddedIPv4,omitempty"`
 }
 
 type IPInfoResponse struct {
@@ -87,6 +131,8 @@ type IPInfoResponse struct {
 }
 
 func Initialize(c *config.Config) {
+	initSessionStore(c)
+
 	// changed to use Noto Sans instead of OpenSans, due to issue:
 	// https://github.com/golang/freetype/issues/8
 	fLight, err := freetype.ParseFont(fontLightBytes)
@@ -151,7 +197,12 @@ func Record(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ipAddr, _, _ := net.SplitHostPort(r.RemoteAddr)
+	// middleware.RealIP sets RemoteAddr without port for proxied requests
+	ipAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ipAddr = r.RemoteAddr
+	}
+	clientAddr := ipAddr
 	userAgent := r.UserAgent()
 	language := r.Header.Get("Accept-Language")
 
@@ -174,6 +225,10 @@ func Record(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var record schema.TelemetryData
+	// the site is looked up before the address is redacted
+	if site := sites.Lookup(clientAddr); site != nil {
+		record.Site = site.Label()
+	}
 	record.IPAddress = ipAddr
 	if ispInfo == "" {
 		record.ISPInfo = "{}"
@@ -183,30 +238,163 @@ func Record(w http.ResponseWriter, r *http.Request) {
 	record.Extra = extra
 	record.UserAgent = userAgent
 	record.Language = language
-	record.Download = download
-	record.Upload = upload
-	record.Ping = ping
-	record.Jitter = jitter
 	record.Log = logs
 
+	for _, m := range []struct {
+		name  string
+		value string
+		dest  *float64
+	}{
+		{"dl", download, &record.Download},
+		{"ul", upload, &record.Upload},
+		{"ping", ping, &record.Ping},
+		{"jitter", jitter, &record.Jitter},
+	} {
+		v, err := parseMeasurement(m.name, m.value)
+		if err != nil {
+			log.Warnf("Rejecting telemetry from %s: %s", ipAddr, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		*m.dest = v
+	}
+
+	sessionID := r.FormValue("session")
+	var sess *session.Session
+	if sessionID != "" {
+		sess = session.Get(sessionID)
+		// sessions are bound to the client that requested them, as the ID travels in URLs
+		if sess == nil || !sess.Active() || sess.IP != clientAddr {
+			log.WithFields(log.Fields{"session": sessionID, "ip": ipAddr}).Warn("Rejecting telemetry for unknown test session")
+			http.Error(w, "Unknown test session", http.StatusBadRequest)
+			return
+		}
+		if !sess.Submit() {
+			log.WithFields(log.Fields{"session": sessionID, "ip": ipAddr}).Warn("Rejecting second result for test session")
+			http.Error(w, "Test session already reported a result", http.StatusConflict)
+			return
+		}
+	} else if conf.RequireSession {
+		log.WithField("ip", ipAddr).Warn("Rejecting telemetry without test session")
+		http.Error(w, "Missing test session", http.StatusBadRequest)
+		return
+	}
+
+	if sess != nil {
+		download, upload := sess.Measurement(session.Download), sess.Measurement(session.Upload)
+		record.ServerDownload = download.Mbps()
+		record.ServerUpload = upload.Mbps()
+		record.Flags = strings.Join(plausibilityFlags(&record, conf.ImplausibleSpeedFactor), ",")
+
+		fields := log.Fields{
+			"download_bytes": download.Bytes,
+			"upload_bytes":   upload.Bytes,
+			"pings":          sess.Pings(),
+			"server_dl":      record.ServerDownload,
+			"server_ul":      record.ServerUpload,
+			"dl":             record.Download,
+			"ul":             record.Upload,
+			"flags":          record.Flags,
+		}
+		if latency, ok := sess.Latency(); ok {
+			record.ServerPing = latency.Ping
+			record.ServerJitter = latency.Jitter
+			record.PacketLoss = latency.Loss
+			fields["server_ping"] = record.ServerPing
+			fields["server_jitter"] = record.ServerJitter
+			fields["packet_loss"] = record.PacketLoss
+		}
+		if loaded, ok := sess.LoadedLatency(); ok {
+			record.LoadedPingDownload = loaded.DownloadPing
+			record.LoadedPingUpload = loaded.UploadPing
+			record.LoadedPacketLoss = loaded.Loss
+			record.Bufferbloat = loaded.Grade
+			if samples, err := json.Marshal(loaded.Samples); err == nil {
+				record.LoadedLatency = string(samples)
+			}
+			fields["loaded_ping_dl"] = record.LoadedPingDownload
+			fields["loaded_ping_ul"] = record.LoadedPingUpload
+			fields["loaded_packet_loss"] = record.LoadedPacketLoss
+			fields["bufferbloat"] = record.Bufferbloat
+		}
+		sess.Logger().WithFields(fields).Info("Test session finished")
+
+		if record.Flags != "" {
+			sess.Logger().Warnf("Telemetry flagged as implausible: %s", record.Flags)
+		}
+	}
+
 	t := time.Now()
 	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
 	uuid := ulid.MustNew(ulid.Timestamp(t), entropy)
 	record.UUID = uuid.String()
 
-	err := database.DB.Insert(&record)
+	err = database.DB.Insert(&record)
 	if err != nil {
+		metrics.TelemetryInsertErrors.Inc()
 		log.Errorf("Error inserting into database: %s", err)
+		// the client may retry
+		if sess != nil {
+			sess.Unsubmit()
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	// a session can only report one result
+	if sess != nil {
+		session.Remove(sess.ID)
+	}
+
 	if _, err := w.Write([]byte("id " + uuid.String())); err != nil {
 		log.Errorf("Error writing ID to telemetry request: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+// plausibilityFlags compares the speeds reported by the client with the throughput the
+// server measured for the session. The client leaves out TCP slow start and applies
+// overhead compensation, so it is expected to report somewhat more than the server saw,
+// but not more than factor times as much.
+func plausibilityFlags(record *schema.TelemetryData, factor float64) []string {
+	var flags []string
+	for _, c := range []struct {
+		reported, measured   float64
+		notMeasured, tooFast string
+	}{
+		{record.Download, record.ServerDownload, flagDownloadNotMeasured, flagDownloadImplausible},
+		{record.Upload, record.ServerUpload, flagUploadNotMeasured, flagUploadImplausible},
+	} {
+		switch {
+		case c.reported == 0:
+		case c.measured == 0:
+			flags = append(flags, c.notMeasured)
+		case factor > 0 && c.reported > c.measured*factor:
+			flags = append(flags, c.tooFast)
+		}
+	}
+	return flags
+}
+
+// parseMeasurement converts a speed (Mbit/s) or latency (ms) value sent by the client. The
+// JavaScript client sends an empty string for skipped tests and "Fail" for failed ones,
+// which are both stored as 0.
+func parseMeasurement(name, value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "Fail" {
+		return 0, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value %q", name, value)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) || f < 0 {
+		return 0, fmt.Errorf("%s value out of range: %s", name, value)
+	}
+	return f, nil
+}
+
 func DrawPNG(w http.ResponseWriter, r *http.Request) {
 	conf := config.LoadedConfig()
 
@@ -284,7 +472,7 @@ func DrawPNG(w http.ResponseWriter, r *http.Request) {
 
 	// ping value
 	drawer.Face = pingJitterValueFace
-	pingValue := strings.Split(record.Ping, ".")[0]
+	pingValue := strconv.Itoa(int(record.Ping))
 	p = drawer.MeasureString(pingValue)
 
 	x = canvasWidth/4 - (p.Round()+msLength.Round())/2
@@ -299,11 +487,12 @@ func DrawPNG(w http.ResponseWriter, r *http.Request) {
 
 	// jitter value
 	drawer.Face = pingJitterValueFace
-	p = drawer.MeasureString(record.Jitter)
+	jitterValue := strconv.FormatFloat(record.Jitter, 'f', 2, 64)
+	p = drawer.MeasureString(jitterValue)
 	x = canvasWidth*3/4 - (p.Round()+msLength.Round())/2
 	drawer.Dot = freetype.Pt(x, canvasHeight*11/40)
 	drawer.Src = colorJitter
-	drawer.DrawString(record.Jitter)
+	drawer.DrawString(jitterValue)
 	drawer.Face = smallLabelFace
 	x = x + p.Round()
 	drawer.Dot = freetype.Pt(x, canvasHeight*11/40)
@@ -312,18 +501,20 @@ func DrawPNG(w http.ResponseWriter, r *http.Request) {
 
 	// download value
 	drawer.Face = upDownValueFace
-	p = drawer.MeasureString(record.Download)
+	downloadValue := strconv.FormatFloat(record.Download, 'f', 2, 64)
+	p = drawer.MeasureString(downloadValue)
 	x = canvasWidth/4 - p.Round()/2
 	drawer.Dot = freetype.Pt(x, canvasHeight*27/40-middleOffset)
 	drawer.Src = colorDownload
-	drawer.DrawString(record.Download)
+	drawer.DrawString(downloadValue)
 
 	// upload value
-	p = drawer.MeasureString(record.Upload)
+	uploadValue := strconv.FormatFloat(record.Upload, 'f', 2, 64)
+	p = drawer.MeasureString(uploadValue)
 	x = canvasWidth*3/4 - p.Round()/2
 	drawer.Dot = freetype.Pt(x, canvasHeight*27/40-middleOffset)
 	drawer.Src = colorUpload
-	drawer.DrawString(record.Upload)
+	drawer.DrawString(uploadValue)
 
 	// watermark
 	ctx := freetype.NewContext()
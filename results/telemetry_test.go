@@ -0,0 +1,78 @@
+package results
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/librespeed/speedtest/config"
+	"github.com/librespeed/speedtest/database"
+	"github.com/librespeed/speedtest/database/memory"
+	"github.com/librespeed/speedtest/database/schema"
+	"github.com/librespeed/speedtest/session"
+)
+
+// failingDB fails all inserts and stores nothing
+type failingDB struct {
+	database.DataAccess
+}
+
+func (failingDB) Insert(*schema.TelemetryData) error {
+	return errors.New("database unavailable")
+}
+
+func loadTestConfig(t *testing.T) {
+	t.Helper()
+	settings := filepath.Join(t.TempDir(), "settings.toml")
+	if err := os.WriteFile(settings, []byte("database_type=\"memory\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config.Load(settings)
+}
+
+func postTelemetry(sessionID string) *httptest.ResponseRecorder {
+	form := url.Values{"dl": {"90.5"}, "ul": {"20.1"}, "ping": {"12"}, "jitter": {"1.5"}, "session": {sessionID}}
+	r := httptest.NewRequest(http.MethodPost, "/results/telemetry", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "192.0.2.10:40000"
+	w := httptest.NewRecorder()
+	Record(w, r)
+	return w
+}
+
+func TestRecordRetryAfterFailedInsert(t *testing.T) {
+	loadTestConfig(t)
+	previous := database.DB
+	defer func() { database.DB = previous }()
+
+	sess, err := session.New("192.0.2.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.AddPing()
+
+	database.DB = failingDB{}
+	if w := postTelemetry(sess.ID); w.Code != http.StatusInternalServerError {
+		t.Fatalf("failed insert answered %d, want 500", w.Code)
+	}
+
+	mem := memory.Open(10)
+	database.DB = mem
+	w := postTelemetry(sess.ID)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "id ") {
+		t.Fatalf("retry answered %d %q, want the test ID", w.Code, w.Body)
+	}
+	if _, err := mem.FetchByUUID(strings.TrimPrefix(w.Body.String(), "id ")); err != nil {
+		t.Errorf("retried result was not stored: %s", err)
+	}
+
+	// the session ends with its stored result
+	if w := postTelemetry(sess.ID); w.Code != http.StatusBadRequest {
+		t.Errorf("result after the stored one answered %d, want 400", w.Code)
+	}
+}
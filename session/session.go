@@ -0,0 +1,347 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librespeed/speedtest/ratelimit"
+)
+
+type Direction int
+
+const (
+	Download Direction = iota
+	Upload
+)
+
+const (
+	// sessions are forgotten after this long without activity, or sooner if they never saw any test traffic
+	sessionTTL       = 10 * time.Minute
+	unusedSessionTTL = 2 * time.Minute
+	cleanupInterval  = 30 * time.Second
+
+	// upper bound on tracked sessions, so that a flood of session requests can't exhaust memory
+	maxSessions = 100000
+	// upper bound per client, so that a single client can't use up maxSessions
+	maxSessionsPerClient = 20
+
+	idBytes = 18
+
+	// transfers shorter than this are too short for a meaningful throughput figure
+	minMeasurementDuration = 10 * time.Millisecond
+)
+
+var (
+	ErrTooManySessions       = errors.New("too many active test sessions")
+	ErrTooManyClientSessions = errors.New("too many active test sessions for this client")
+
+	lock     sync.Mutex
+	sessions = make(map[string]*Session)
+	// perClient counts the sessions of each ratelimit.ClientKey
+	perClient = make(map[string]int)
+)
+
+func init() {
+	go cleanup()
+}
+
+// Measurement is the traffic of all streams of one test direction. Streams run in
+// parallel, so throughput is calculated over the time between the start of the first
+// and the end of the last stream.
+type Measurement struct {
+	Bytes int64
+	Start time.Time
+	End   time.Time
+}
+
+// Mbps returns the measured throughput in Mbit/s, or 0 if there is not enough data
+func (m Measurement) Mbps() float64 {
+	d := m.End.Sub(m.Start)
+	if m.Bytes == 0 || d < minMeasurementDuration {
+		return 0
+	}
+	return float64(m.Bytes) * 8 / d.Seconds() / 1000000
+}
+
+// Latency is the result of a latency test run by the server over WebSocket. Ping and
+// Jitter are in milliseconds, Loss is the percentage of probes that were not answered.
+type Latency struct {
+	Ping     float64
+	Jitter   float64
+	Loss     float64
+	Sent     int
+	Received int
+}
+
+// LatencySample is a probe of a loaded latency test
+type LatencySample struct {
+	// Time is the offset from the start of the test in milliseconds
+	Time float64 `json:"t"`
+	// Phase is idle, download or upload, depending on the streams active when the probe was sent
+	Phase string `json:"phase"`
+	// RTT is the round trip time in milliseconds, 0 if the probe was lost
+	RTT float64 `json:"rtt"`
+}
+
+// LoadedLatency is the result of a latency test run alongside the download and upload
+// tests. Pings are the median round trip times in milliseconds per phase, Loss is the
+// percentage of probes lost while the link was loaded and Grade rates the bufferbloat.
+type LoadedLatency struct {
+	IdlePing     float64
+	DownloadPing float64
+	UploadPing   float64
+	Loss         float64
+	Grade        string
+	Samples      []LatencySample
+}
+
+// Session collects the server side view of a single test run
+type Session struct {
+	ID      string
+	IP      string
+	Created time.Time
+
+	client string
+
+	lock         sync.Mutex
+	lastActivity time.Time
+	used         bool
+	submitted    bool
+	measurements [2]Measurement
+	pings        int
+	latency      *Latency
+	loaded       *LoadedLatency
+	streams      [2]int
+}
+
+// Add records n bytes transferred in the given direction by a request started at start
+func (s *Session) Add(direction Direction, start time.Time, n int64) {
+	if n <= 0 {
+		return
+	}
+
+	now := time.Now()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	m := &s.measurements[direction]
+	if m.Start.IsZero() || start.Before(m.Start) {
+		m.Start = start
+	}
+	if now.After(m.End) {
+		m.End = now
+	}
+	m.Bytes += n
+	s.lastActivity = now
+	s.used = true
+}
+
+// AddPing records a latency probe, i.e. a request to the upload endpoint without body
+func (s *Session) AddPing() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pings++
+	s.lastActivity = time.Now()
+	s.used = true
+}
+
+// Pings returns the number of latency probes seen
+func (s *Session) Pings() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pings
+}
+
+// SetLatency records the result of a latency test, replacing an earlier one
+func (s *Session) SetLatency(l Latency) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.latency = &l
+	s.lastActivity = time.Now()
+	s.used = true
+}
+
+// Latency returns the result of the latency test, ok is false if none was run
+func (s *Session) Latency() (l Latency, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.latency == nil {
+		return l, false
+	}
+	return *s.latency, true
+}
+
+// StreamStarted records that a download or upload stream is in progress, until StreamFinished is called
+func (s *Session) StreamStarted(direction Direction) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.streams[direction]++
+	s.lastActivity = time.Now()
+	s.used = true
+}
+
+// StreamFinished records the end of a stream started with StreamStarted
+func (s *Session) StreamFinished(direction Direction) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.streams[direction]--
+}
+
+// Loading returns the direction of the streams in progress, ok is false if there are none.
+// Download wins if streams of both directions overlap.
+func (s *Session) Loading() (direction Direction, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch {
+	case s.streams[Download] > 0:
+		return Download, true
+	case s.streams[Upload] > 0:
+		return Upload, true
+	}
+	return 0, false
+}
+
+// SetLoadedLatency records the result of a loaded latency test, replacing an earlier one
+func (s *Session) SetLoadedLatency(l LoadedLatency) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.loaded = &l
+	s.lastActivity = time.Now()
+	s.used = true
+}
+
+// LoadedLatency returns the result of the loaded latency test, ok is false if none was run
+func (s *Session) LoadedLatency() (l LoadedLatency, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.loaded == nil {
+		return l, false
+	}
+	return *s.loaded, true
+}
+
+// Active reports whether any test traffic was seen for the session
+func (s *Session) Active() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.measurements[Download].Bytes > 0 || s.measurements[Upload].Bytes > 0 || s.pings > 0 || s.latency != nil
+}
+
+// Submit marks the result of the session as reported. It returns false if it already was,
+// so that each session can report only one result.
+func (s *Session) Submit() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.submitted {
+		return false
+	}
+	s.submitted = true
+	return true
+}
+
+// Unsubmit allows the session to report its result again, e.g. after it couldn't be stored
+func (s *Session) Unsubmit() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.submitted = false
+}
+
+// Logger returns a logger with the session ID and client IP as fields
+func (s *Session) Logger() *log.Entry {
+	return log.WithFields(log.Fields{"session": s.ID, "ip": s.IP})
+}
+
+// Measurement returns a snapshot of the traffic in the given direction
+func (s *Session) Measurement(direction Direction) Measurement {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.measurements[direction]
+}
+
+// New creates a session for a test run by the client at ip
+func New(ip string) (*Session, error) {
+	b := make([]byte, idBytes)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s := &Session{
+		ID:           base64.RawURLEncoding.EncodeToString(b),
+		IP:           ip,
+		Created:      now,
+		client:       ratelimit.ClientKey(ip),
+		lastActivity: now,
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(sessions) >= maxSessions {
+		return nil, ErrTooManySessions
+	}
+	if perClient[s.client] >= maxSessionsPerClient {
+		return nil, ErrTooManyClientSessions
+	}
+	sessions[s.ID] = s
+	perClient[s.client]++
+	return s, nil
+}
+
+// Get returns the session with the given ID, or nil if it doesn't exist
+func Get(id string) *Session {
+	lock.Lock()
+	defer lock.Unlock()
+	return sessions[id]
+}
+
+// Remove forgets a session, e.g. once its result has been recorded
+func Remove(id string) {
+	lock.Lock()
+	defer lock.Unlock()
+	remove(id)
+}
+
+// remove forgets a session, lock must be held
+func remove(id string) {
+	s, ok := sessions[id]
+	if !ok {
+		return
+	}
+	delete(sessions, id)
+	if perClient[s.client]--; perClient[s.client] <= 0 {
+		delete(perClient, s.client)
+	}
+}
+
+func cleanup() {
+	for range time.Tick(cleanupInterval) {
+		expire(time.Now())
+	}
+}
+
+// expire removes the sessions that have been idle for longer than their TTL at now
+func expire(now time.Time) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for id, s := range sessions {
+		s.lock.Lock()
+		ttl := sessionTTL
+		if !s.used {
+			ttl = unusedSessionTTL
+		}
+		// streams in progress keep their session alive
+		idle := s.lastActivity.Before(now.Add(-ttl)) && s.streams[Download] == 0 && s.streams[Upload] == 0
+		s.lock.Unlock()
+		if idle {
+			remove(id)
+		}
+	}
+}
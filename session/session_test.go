@@ -0,0 +1,168 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewAndRemove(t *testing.T) {
+	s, err := New("192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.ID) != 24 || Get(s.ID) != s {
+		t.Fatalf("session %q was not registered", s.ID)
+	}
+	Remove(s.ID)
+	if Get(s.ID) != nil {
+		t.Error("removed session is still registered")
+	}
+	if _, ok := perClient[s.client]; ok {
+		t.Error("removed session is still counted for its client")
+	}
+}
+
+func TestSessionsPerClient(t *testing.T) {
+	var ids []string
+	defer func() {
+		for _, id := range ids {
+			Remove(id)
+		}
+	}()
+
+	for i := 0; i < maxSessionsPerClient; i++ {
+		// IPv6 clients are counted per /64
+		s, err := New("2001:db8:1:2::" + string(rune('a'+i%6)))
+		if err != nil {
+			t.Fatalf("session %d: %s", i, err)
+		}
+		ids = append(ids, s.ID)
+	}
+	if _, err := New("2001:db8:1:2::ffff"); !errors.Is(err, ErrTooManyClientSessions) {
+		t.Fatalf("session over the limit: %v, want ErrTooManyClientSessions", err)
+	}
+	other, err := New("2001:db8:1:3::1")
+	if err != nil {
+		t.Fatalf("session of another client: %s", err)
+	}
+	ids = append(ids, other.ID)
+
+	Remove(ids[0])
+	s, err := New("2001:db8:1:2::1")
+	if err != nil {
+		t.Fatalf("session after removing one: %s", err)
+	}
+	ids = append(ids, s.ID)
+}
+
+func TestSubmit(t *testing.T) {
+	s := &Session{}
+	if !s.Submit() {
+		t.Fatal("first Submit() = false")
+	}
+	if s.Submit() {
+		t.Fatal("second Submit() = true")
+	}
+	s.Unsubmit()
+	if !s.Submit() {
+		t.Error("Submit() after Unsubmit() = false")
+	}
+}
+
+func TestActive(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(*Session)
+		want   bool
+	}{
+		{"no traffic", func(s *Session) {}, false},
+		{"stream without bytes", func(s *Session) { s.StreamStarted(Download) }, false},
+		{"download", func(s *Session) { s.Add(Download, time.Now(), 1) }, true},
+		{"upload", func(s *Session) { s.Add(Upload, time.Now(), 1) }, true},
+		{"ping", func(s *Session) { s.AddPing() }, true},
+		{"latency test", func(s *Session) { s.SetLatency(Latency{Sent: 1}) }, true},
+	}
+	for _, tt := range tests {
+		s := &Session{}
+		tt.record(s)
+		if got := s.Active(); got != tt.want {
+			t.Errorf("%s: Active() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMeasurement(t *testing.T) {
+	s := &Session{}
+	start := time.Now().Add(-time.Second)
+	s.Add(Download, start, 500000)
+	s.Add(Download, start.Add(100*time.Millisecond), 750000)
+	s.Add(Download, start, 0)
+
+	m := s.Measurement(Download)
+	if m.Bytes != 1250000 || !m.Start.Equal(start) {
+		t.Fatalf("measurement = %+v", m)
+	}
+	// 10 Mbit in about a second
+	if mbps := m.Mbps(); mbps < 9 || mbps > 10.1 {
+		t.Errorf("Mbps() = %v, want about 10", mbps)
+	}
+	if mbps := (Measurement{Bytes: 1000, Start: start, End: start.Add(time.Millisecond)}).Mbps(); mbps != 0 {
+		t.Errorf("Mbps() of a short transfer = %v, want 0", mbps)
+	}
+}
+
+func TestLoading(t *testing.T) {
+	s := &Session{}
+	if _, ok := s.Loading(); ok {
+		t.Error("idle session is loading")
+	}
+	s.StreamStarted(Upload)
+	if d, ok := s.Loading(); !ok || d != Upload {
+		t.Errorf("Loading() = %v, %v, want upload", d, ok)
+	}
+	s.StreamStarted(Download)
+	if d, ok := s.Loading(); !ok || d != Download {
+		t.Errorf("Loading() with both directions = %v, %v, want download", d, ok)
+	}
+	s.StreamFinished(Download)
+	s.StreamFinished(Upload)
+	if _, ok := s.Loading(); ok {
+		t.Error("session is loading after all streams finished")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	unused, _ := New("192.0.2.20")
+	used, _ := New("192.0.2.21")
+	used.AddPing()
+	streaming, _ := New("192.0.2.22")
+	streaming.StreamStarted(Download)
+	defer func() {
+		for _, s := range []*Session{unused, used, streaming} {
+			Remove(s.ID)
+		}
+	}()
+
+	now := time.Now()
+	expire(now.Add(unusedSessionTTL / 2))
+	if Get(unused.ID) == nil {
+		t.Fatal("unused session expired early")
+	}
+
+	expire(now.Add(unusedSessionTTL + time.Second))
+	if Get(unused.ID) != nil {
+		t.Error("unused session did not expire")
+	}
+	if Get(used.ID) == nil {
+		t.Error("used session expired with the TTL of unused ones")
+	}
+
+	expire(now.Add(sessionTTL + time.Second))
+	if Get(used.ID) != nil {
+		t.Error("used session did not expire")
+	}
+	if Get(streaming.ID) == nil {
+		t.Error("session with a running stream expired")
+	}
+}
@@ -0,0 +1,107 @@
+package sites
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/librespeed/speedtest/config"
+)
+
+// Site is a location of the internal network, e.g. an office
+type Site struct {
+	Name     string   `mapstructure:"name"`
+	Building string   `mapstructure:"building"`
+	VLAN     string   `mapstructure:"vlan"`
+	Networks []string `mapstructure:"networks"`
+}
+
+// Label joins the non-empty parts of the site description, e.g. "Berlin / Building 2 / VLAN 10"
+func (s *Site) Label() string {
+	parts := []string{s.Name}
+	if s.Building != "" {
+		parts = append(parts, s.Building)
+	}
+	if s.VLAN != "" {
+		parts = append(parts, "VLAN "+s.VLAN)
+	}
+	return strings.Join(parts, " / ")
+}
+
+type network struct {
+	prefix *net.IPNet
+	site   *Site
+}
+
+var (
+	// sorted from most to least specific, so that the first match is the longest prefix
+	networks []network
+)
+
+// Load reads the site mapping file configured with site_mapping_file, if any
+func Load(conf *config.Config) {
+	if conf.SiteMappingFile == "" {
+		return
+	}
+
+	n, err := parse(conf.SiteMappingFile)
+	if err != nil {
+		log.Fatalf("Error loading site mapping: %s", err)
+	}
+	networks = n
+	log.Infof("Loaded %d site networks from %s", len(networks), conf.SiteMappingFile)
+}
+
+// parse reads a TOML file with a [[site]] table per site
+func parse(path string) ([]network, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var list []*Site
+	if err := v.UnmarshalKey("site", &list); err != nil {
+		return nil, err
+	}
+
+	var result []network
+	for i, site := range list {
+		if site.Name == "" {
+			return nil, fmt.Errorf("site %d has no name", i+1)
+		}
+		for _, cidr := range site.Networks {
+			_, prefix, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("site %s: %w", site.Name, err)
+			}
+			result = append(result, network{prefix: prefix, site: site})
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		a, _ := result[i].prefix.Mask.Size()
+		b, _ := result[j].prefix.Mask.Size()
+		return a > b
+	})
+	return result, nil
+}
+
+// Lookup returns the site of the network containing addr, or nil
+func Lookup(addr string) *Site {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+
+	for _, n := range networks {
+		if n.prefix.Contains(ip) {
+			return n.site
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package sites
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMapping = `
+[[site]]
+name = "Berlin"
+building = "HQ"
+networks = ["10.0.0.0/8", "2001:db8::/32"]
+
+[[site]]
+name = "Berlin"
+building = "Lab"
+vlan = "20"
+networks = ["10.20.0.0/16"]
+
+[[site]]
+name = "Hamburg"
+networks = ["10.20.30.0/24", "192.168.1.0/24"]
+`
+
+func writeMapping(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sites.toml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadMapping(t *testing.T, content string) {
+	t.Helper()
+	n, err := parse(writeMapping(t, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := networks
+	networks = n
+	t.Cleanup(func() { networks = previous })
+}
+
+func TestLookup(t *testing.T) {
+	loadMapping(t, testMapping)
+
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"10.1.2.3", "Berlin / HQ"},
+		{"10.20.1.1", "Berlin / Lab / VLAN 20"},
+		{"10.20.30.40", "Hamburg"},
+		{"192.168.1.200", "Hamburg"},
+		{"::ffff:10.20.1.1", "Berlin / Lab / VLAN 20"},
+		{"2001:db8:1::1", "Berlin / HQ"},
+		{"192.168.2.1", ""},
+		{"2001:db9::1", ""},
+		{"not an address", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if site := Lookup(tt.addr); site != nil {
+			got = site.Label()
+		}
+		if got != tt.want {
+			t.Errorf("Lookup(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestLookupWithoutMapping(t *testing.T) {
+	loadMapping(t, "")
+	if site := Lookup("10.1.2.3"); site != nil {
+		t.Errorf("Lookup() = %q without site mapping", site.Label())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"invalid network", "[[site]]\nname = \"Berlin\"\nnetworks = [\"10.0.0.0/33\"]\n", "site Berlin"},
+		{"missing name", "[[site]]\nnetworks = [\"10.0.0.0/8\"]\n", "site 1 has no name"},
+	}
+	for _, tt := range tests {
+		_, err := parse(writeMapping(t, tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: parse() error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
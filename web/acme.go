@@ -0,0 +1,95 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/librespeed/speedtest/config"
+)
+
+var (
+	// acmeManager obtains certificates when ACME is enabled
+	acmeManager *autocert.Manager
+)
+
+// setupACME creates the certificate manager for acme_domains. Certificates are requested on
+// the first TLS handshake for a domain and renewed in the background before they expire.
+func setupACME(conf *config.Config) error {
+	if len(conf.ACMEDomains) == 0 {
+		return fmt.Errorf("acme_domains is required for ACME")
+	}
+
+	client := &acme.Client{DirectoryURL: conf.ACMEDirectoryURL}
+	if conf.ACMEDirectoryCAFile != "" {
+		// test CAs like Pebble serve their directory with a certificate of their own
+		pem, err := os.ReadFile(conf.ACMEDirectoryCAFile)
+		if err != nil {
+			return fmt.Errorf("cannot read ACME directory CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", conf.ACMEDirectoryCAFile)
+		}
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		}
+	}
+
+	acmeManager = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(conf.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(conf.ACMEDomains...),
+		Email:      conf.ACMEEmail,
+		Client:     client,
+	}
+	log.Infof("Using ACME directory %s for certificates of %v, caching them in %s", conf.ACMEDirectoryURL, conf.ACMEDomains, conf.ACMECacheDir)
+	return nil
+}
+
+// startACMEChallengeServer answers HTTP-01 challenges on addr and redirects everything else to HTTPS
+func startACMEChallengeServer(addr string, running *servers) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %w", addr, err)
+	}
+
+	srv := &http.Server{Handler: acmeManager.HTTPHandler(nil)}
+	log.Infof("Starting ACME HTTP-01 challenge server on %s", l.Addr())
+	running.start(srv, func() error {
+		return srv.Serve(l)
+	})
+	return nil
+}
+
+// acmeTLSConfig answers TLS-ALPN-01 challenges and serves the certificates of the manager
+func acmeTLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: acmeManager.GetCertificate,
+		// http/1.1 and h2 are added by http.Server.ServeTLS as configured for the listener
+		NextProtos: []string{acme.ALPNProto},
+	}
+}
+
+// prefetchCertificates obtains the certificates for all domains once the listeners are
+// bound, so that the first visitors don't have to wait and errors show up in the log
+func prefetchCertificates(conf *config.Config) {
+	for _, domain := range conf.ACMEDomains {
+		go func(domain string) {
+			cert, err := acmeManager.GetCertificate(&tls.ClientHelloInfo{ServerName: domain})
+			if err != nil {
+				log.Errorf("Cannot obtain certificate for %s: %s", domain, err)
+				return
+			}
+			log.Infof("Certificate for %s is valid until %s", domain, cert.Leaf.NotAfter.Format(time.RFC3339))
+		}(domain)
+	}
+}
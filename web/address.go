@@ -0,0 +1,104 @@
+package web
+
+import (
+	"net/netip"
+	"sort"
+
+	"github.com/librespeed/speedtest/results"
+)
+
+// specialRange is an entry of the IANA IPv4 and IPv6 special-purpose address registries
+type specialRange struct {
+	prefix      netip.Prefix
+	class       string
+	description string
+	// global ranges are publicly routable and get ISP info
+	global bool
+	// embedded extracts the IPv4 address carried by transition mechanisms
+	embedded func(netip.Addr) netip.Addr
+}
+
+var (
+	// sorted from most to least specific in init, so that the first match is the longest prefix
+	specialRanges = []specialRange{
+		{prefix: netip.MustParsePrefix("0.0.0.0/8"), class: results.AddressUnspecified, description: "unspecified IPv4 access"},
+		{prefix: netip.MustParsePrefix("10.0.0.0/8"), class: results.AddressPrivate, description: "private IPv4 access"},
+		{prefix: netip.MustParsePrefix("100.64.0.0/10"), class: results.AddressCGNAT, description: "CGNAT IPv4 access"},
+		{prefix: netip.MustParsePrefix("127.0.0.0/8"), class: results.AddressLoopback, description: "localhost IPv4 access"},
+		{prefix: netip.MustParsePrefix("169.254.0.0/16"), class: results.AddressLinkLocal, description: "link-local IPv4 access"},
+		{prefix: netip.MustParsePrefix("172.16.0.0/12"), class: results.AddressPrivate, description: "private IPv4 access"},
+		{prefix: netip.MustParsePrefix("192.0.0.0/24"), class: results.AddressReserved, description: "IETF protocol assignment IPv4 access"},
+		{prefix: netip.MustParsePrefix("192.0.2.0/24"), class: results.AddressDocumentation, description: "documentation IPv4 access"},
+		{prefix: netip.MustParsePrefix("192.88.99.0/24"), class: results.AddressReserved, description: "6to4 relay anycast IPv4 access"},
+		{prefix: netip.MustParsePrefix("192.168.0.0/16"), class: results.AddressPrivate, description: "private IPv4 access"},
+		{prefix: netip.MustParsePrefix("198.18.0.0/15"), class: results.AddressBenchmarking, description: "benchmarking IPv4 access"},
+		{prefix: netip.MustParsePrefix("198.51.100.0/24"), class: results.AddressDocumentation, description: "documentation IPv4 access"},
+		{prefix: netip.MustParsePrefix("203.0.113.0/24"), class: results.AddressDocumentation, description: "documentation IPv4 access"},
+		{prefix: netip.MustParsePrefix("224.0.0.0/4"), class: results.AddressMulticast, description: "multicast IPv4 access"},
+		{prefix: netip.MustParsePrefix("240.0.0.0/4"), class: results.AddressReserved, description: "reserved IPv4 access"},
+		{prefix: netip.MustParsePrefix("255.255.255.255/32"), class: results.AddressBroadcast, description: "broadcast IPv4 access"},
+
+		{prefix: netip.MustParsePrefix("::/128"), class: results.AddressUnspecified, description: "unspecified IPv6 access"},
+		{prefix: netip.MustParsePrefix("::1/128"), class: results.AddressLoopback, description: "localhost IPv6 access"},
+		{prefix: netip.MustParsePrefix("64:ff9b::/96"), class: results.AddressNAT64, description: "NAT64 IPv6 access", global: true, embedded: lastIPv4},
+		{prefix: netip.MustParsePrefix("64:ff9b:1::/48"), class: results.AddressNAT64, description: "local NAT64 IPv6 access", embedded: lastIPv4},
+		{prefix: netip.MustParsePrefix("100::/64"), class: results.AddressReserved, description: "discard-only IPv6 access"},
+		{prefix: netip.MustParsePrefix("2001::/23"), class: results.AddressReserved, description: "IETF protocol assignment IPv6 access"},
+		{prefix: netip.MustParsePrefix("2001::/32"), class: results.AddressTeredo, description: "Teredo IPv6 access", global: true, embedded: teredoIPv4},
+		{prefix: netip.MustParsePrefix("2001:2::/48"), class: results.AddressBenchmarking, description: "benchmarking IPv6 access"},
+		{prefix: netip.MustParsePrefix("2001:db8::/32"), class: results.AddressDocumentation, description: "documentation IPv6 access"},
+		{prefix: netip.MustParsePrefix("2002::/16"), class: results.Address6to4, description: "6to4 IPv6 access", global: true, embedded: sixToFourIPv4},
+		{prefix: netip.MustParsePrefix("3fff::/20"), class: results.AddressDocumentation, description: "documentation IPv6 access"},
+		{prefix: netip.MustParsePrefix("fc00::/7"), class: results.AddressUniqueLocal, description: "ULA IPv6 access"},
+		{prefix: netip.MustParsePrefix("fe80::/10"), class: results.AddressLinkLocal, description: "link-local IPv6 access"},
+		{prefix: netip.MustParsePrefix("ff00::/8"), class: results.AddressMulticast, description: "multicast IPv6 access"},
+	}
+)
+
+func init() {
+	sort.SliceStable(specialRanges, func(i, j int) bool {
+		return specialRanges[i].prefix.Bits() > specialRanges[j].prefix.Bits()
+	})
+}
+
+// classifyAddress describes addr, which must not be an IPv4-mapped IPv6 address
+func classifyAddress(addr netip.Addr) (results.AddressInfo, string) {
+	info := results.AddressInfo{Version: 6, Class: results.AddressPublic, Global: true}
+	if addr.Is4() {
+		info.Version = 4
+	}
+
+	for _, r := range specialRanges {
+		if !r.prefix.Contains(addr) {
+			continue
+		}
+
+		info.Class = r.class
+		info.Range = r.prefix.String()
+		info.Global = r.global
+		if r.embedded != nil {
+			info.EmbeddedIPv4 = r.embedded(addr).String()
+		}
+		return info, r.description
+	}
+
+	return info, ""
+}
+
+// lastIPv4 returns the IPv4 address in the last 32 bits, as used by NAT64 (RFC 6052)
+func lastIPv4(addr netip.Addr) netip.Addr {
+	b := addr.As16()
+	return netip.AddrFrom4([4]byte{b[12], b[13], b[14], b[15]})
+}
+
+// teredoIPv4 returns the obfuscated public address of the Teredo client (RFC 4380)
+func teredoIPv4(addr netip.Addr) netip.Addr {
+	b := addr.As16()
+	return netip.AddrFrom4([4]byte{^b[12], ^b[13], ^b[14], ^b[15]})
+}
+
+// sixToFourIPv4 returns the IPv4 address following the 2002::/16 prefix (RFC 3056)
+func sixToFourIPv4(addr netip.Addr) netip.Addr {
+	b := addr.As16()
+	return netip.AddrFrom4([4]byte{b[2], b[3], b[4], b[5]})
+}
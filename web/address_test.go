@@ -0,0 +1,51 @@
+package web
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/librespeed/speedtest/results"
+)
+
+func TestClassifyAddress(t *testing.T) {
+	tests := []struct {
+		addr        string
+		want        results.AddressInfo
+		description string
+	}{
+		{"203.0.114.1", results.AddressInfo{Version: 4, Class: results.AddressPublic, Global: true}, ""},
+		{"2a00:1450::1", results.AddressInfo{Version: 6, Class: results.AddressPublic, Global: true}, ""},
+		{"10.1.2.3", results.AddressInfo{Version: 4, Class: results.AddressPrivate, Range: "10.0.0.0/8"}, "private IPv4 access"},
+		{"172.31.255.255", results.AddressInfo{Version: 4, Class: results.AddressPrivate, Range: "172.16.0.0/12"}, "private IPv4 access"},
+		{"172.32.0.1", results.AddressInfo{Version: 4, Class: results.AddressPublic, Global: true}, ""},
+		{"100.64.0.1", results.AddressInfo{Version: 4, Class: results.AddressCGNAT, Range: "100.64.0.0/10"}, "CGNAT IPv4 access"},
+		{"127.0.0.1", results.AddressInfo{Version: 4, Class: results.AddressLoopback, Range: "127.0.0.0/8"}, "localhost IPv4 access"},
+		{"169.254.1.1", results.AddressInfo{Version: 4, Class: results.AddressLinkLocal, Range: "169.254.0.0/16"}, "link-local IPv4 access"},
+		{"198.19.0.1", results.AddressInfo{Version: 4, Class: results.AddressBenchmarking, Range: "198.18.0.0/15"}, "benchmarking IPv4 access"},
+		{"239.1.1.1", results.AddressInfo{Version: 4, Class: results.AddressMulticast, Range: "224.0.0.0/4"}, "multicast IPv4 access"},
+		// the broadcast address is more specific than the reserved 240.0.0.0/4
+		{"255.255.255.255", results.AddressInfo{Version: 4, Class: results.AddressBroadcast, Range: "255.255.255.255/32"}, "broadcast IPv4 access"},
+		{"255.255.255.254", results.AddressInfo{Version: 4, Class: results.AddressReserved, Range: "240.0.0.0/4"}, "reserved IPv4 access"},
+		{"::", results.AddressInfo{Version: 6, Class: results.AddressUnspecified, Range: "::/128"}, "unspecified IPv6 access"},
+		{"::1", results.AddressInfo{Version: 6, Class: results.AddressLoopback, Range: "::1/128"}, "localhost IPv6 access"},
+		{"fd12:3456::1", results.AddressInfo{Version: 6, Class: results.AddressUniqueLocal, Range: "fc00::/7"}, "ULA IPv6 access"},
+		{"fe80::1", results.AddressInfo{Version: 6, Class: results.AddressLinkLocal, Range: "fe80::/10"}, "link-local IPv6 access"},
+		{"2001:db8::1", results.AddressInfo{Version: 6, Class: results.AddressDocumentation, Range: "2001:db8::/32"}, "documentation IPv6 access"},
+		{"2001:2::1", results.AddressInfo{Version: 6, Class: results.AddressBenchmarking, Range: "2001:2::/48"}, "benchmarking IPv6 access"},
+		{"2001:10::1", results.AddressInfo{Version: 6, Class: results.AddressReserved, Range: "2001::/23"}, "IETF protocol assignment IPv6 access"},
+		{"64:ff9b::192.0.2.33", results.AddressInfo{Version: 6, Class: results.AddressNAT64, Range: "64:ff9b::/96", Global: true, EmbeddedIPv4: "192.0.2.33"}, "NAT64 IPv6 access"},
+		{"64:ff9b:1::10.0.0.1", results.AddressInfo{Version: 6, Class: results.AddressNAT64, Range: "64:ff9b:1::/48", EmbeddedIPv4: "10.0.0.1"}, "local NAT64 IPv6 access"},
+		// Teredo is more specific than the IETF protocol assignments it belongs to
+		{"2001:0:4136:e378:8000:63bf:3fff:fdd2", results.AddressInfo{Version: 6, Class: results.AddressTeredo, Range: "2001::/32", Global: true, EmbeddedIPv4: "192.0.2.45"}, "Teredo IPv6 access"},
+		{"2002:c000:204::1", results.AddressInfo{Version: 6, Class: results.Address6to4, Range: "2002::/16", Global: true, EmbeddedIPv4: "192.0.2.4"}, "6to4 IPv6 access"},
+	}
+	for _, tt := range tests {
+		info, description := classifyAddress(netip.MustParseAddr(tt.addr))
+		if info != tt.want {
+			t.Errorf("classifyAddress(%s) = %+v, want %+v", tt.addr, info, tt.want)
+		}
+		if description != tt.description {
+			t.Errorf("classifyAddress(%s) description = %q, want %q", tt.addr, description, tt.description)
+		}
+	}
+}
@@ -37,6 +37,7 @@
             - pingProgress: progress of the ping/jitter test as a float 0-1
             - testState: state of the test (-1=not started, 0=starting, 1=download test, 2=ping+jitter test, 3=upload test, 4=finished, 5=aborted)
             - clientIp: IP address of the client performing the test (and optionally ISP and distance) 
+            - dlLatencyStatus, ulLatencyStatus: ping in ms while the download and upload tests were running, bufferbloatStatus: grade of the latency increase (A+ to F). Only set at the end of the test if test_bufferbloat is enabled
         At the end of the test, the onend function will be called, with a boolean specifying whether the test was aborted or if it ended normally.
         The test can be aborted at any time with abort().
         At the end of the test, it will move to state 4
@@ -356,6 +357,19 @@ Speedtest.prototype = {
         this._selectedServer.server + this._selectedServer.pingURL;
       this._settings.url_getIp =
         this._selectedServer.server + this._selectedServer.getIpURL;
+      // sessions are only used with servers that announce them, older backends don't have the endpoint
+      this._settings.url_session =
+        typeof this._selectedServer.sessionURL === "string"
+          ? this._selectedServer.server + this._selectedServer.sessionURL
+          : "";
+      this._settings.url_latency =
+        typeof this._selectedServer.latencyURL === "string"
+          ? this._selectedServer.server + this._selectedServer.latencyURL
+          : "";
+      this._settings.url_loadedLatency =
+        typeof this._selectedServer.loadedLatencyURL === "string"
+          ? this._selectedServer.server + this._selectedServer.loadedLatencyURL
+          : "";
       if (typeof this._originalExtra !== "undefined") {
         this._settings.telemetry_extra = JSON.stringify({
           server: this._selectedServer.name,
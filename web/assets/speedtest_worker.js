@@ -16,6 +16,10 @@ var dlProgress = 0; //progress of download test 0-1
 var ulProgress = 0; //progress of upload test 0-1
 var pingProgress = 0; //progress of ping+jitter test 0-1
 var testId = null; //test ID (sent back by telemetry if used, null otherwise)
+var sessionId = null; //ID issued by the server and sent with test requests, lets the server match its own measurements to the telemetry
+var dlLatencyStatus = ""; // ping in milliseconds while the download test was running, if test_bufferbloat is enabled
+var ulLatencyStatus = ""; // ping in milliseconds while the upload test was running, if test_bufferbloat is enabled
+var bufferbloatStatus = ""; // bufferbloat grade from A+ to F, if test_bufferbloat is enabled
 
 var log = ""; //telemetry log
 function tlog(s) {
@@ -49,6 +53,10 @@ var settings = {
 	url_ul: "backend/empty.php", // path to an empty file, used for upload test. must be relative to this js file
 	url_ping: "backend/empty.php", // path to an empty file, used for ping test. must be relative to this js file
 	url_getIp: "backend/getIP.php", // path to getIP.php relative to this js file, or a similar thing that outputs the client's ip
+	url_session: "backend/session", // path to the endpoint that issues test session IDs, relative to this js file. set to "" to run tests without session
+	url_loadedLatency: "backend/loaded_latency", // path to the WebSocket endpoint where the server measures latency while the download and upload tests are running, relative to this js file
+	test_bufferbloat: false, // if set to true, latency is also measured during the download and upload tests to grade bufferbloat. requires a session from url_session
+	url_latency: "backend/latency", // path to the WebSocket endpoint where the server measures ping, jitter and packet loss, relative to this js file. set to "" to always measure ping with XHRs to url_ping
 	getIp_ispInfo: true, //if set to true, the server will include ISP info with the IP address
 	getIp_ispInfo_distance: "km", //km or mi=estimate distance from server in km/mi; set to false to disable distance estimation. getIp_ispInfo must be enabled in order for this to work
 	xhr_dlMultistream: 6, // number of download streams to use (can be different if enable_quirks is active)
@@ -69,6 +77,9 @@ var settings = {
 };
 
 var xhr = null; // array of currently active xhr requests
+var ws = null; // WebSocket of the latency test, if active
+var loadedWs = null; // WebSocket of the loaded latency test, open from the start to the end of the test
+var loadedDone = null; // called when the loaded latency test has finished
 var interval = null; // timer used in tests
 var test_pointer = 0; //pointer to the next test to run inside settings.test_order
 
@@ -102,13 +113,17 @@ this.addEventListener("message", function(e) {
 				dlProgress: dlProgress,
 				ulProgress: ulProgress,
 				pingProgress: pingProgress,
-				testId: testId
+				testId: testId,
+				dlLatencyStatus: dlLatencyStatus,
+				ulLatencyStatus: ulLatencyStatus,
+				bufferbloatStatus: bufferbloatStatus
 			})
 		);
 	}
 	if (params[0] === "start" && testState === -1) {
 		// start new test
 		testState = 0;
+		sessionId = null;
 		try {
 			// parse settings, if present
 			var s = {};
@@ -179,13 +194,15 @@ this.addEventListener("message", function(e) {
 		var runNextTest = function() {
 			if (testState == 5) return;
 			if (test_pointer >= settings.test_order.length) {
-				//test is finished
-				if (settings.telemetry_level > 0)
-					sendTelemetry(function(id) {
-						testState = 4;
-						if (id != null) testId = id;
-					});
-				else testState = 4;
+				//test is finished, the loaded latency result has to reach the server before the telemetry
+				stopLoadedLatency(function() {
+					if (settings.telemetry_level > 0)
+						sendTelemetry(function(id) {
+							testState = 4;
+							if (id != null) testId = id;
+						});
+					else testState = 4;
+				});
 				return;
 			}
 			switch (settings.test_order.charAt(test_pointer)) {
@@ -242,13 +259,18 @@ this.addEventListener("message", function(e) {
 					test_pointer++;
 			}
 		};
-		runNextTest();
+		getSession(function() {
+			startLoadedLatency();
+			runNextTest();
+		});
 	}
 	if (params[0] === "abort") {
 		// abort command
         if (testState >= 4) return;
 		tlog("manually aborted");
 		clearRequests(); // stop all xhr activity
+		loadedDone = null; // don't finish the test
+		closeLoadedLatency();
 		runNextTest = null;
 		if (interval) clearInterval(interval); // clear timer if present
 		if (settings.telemetry_level > 1) sendTelemetry(function() {});
@@ -257,6 +279,9 @@ this.addEventListener("message", function(e) {
 		ulStatus = "";
 		pingStatus = "";
 		jitterStatus = "";
+		dlLatencyStatus = "";
+		ulLatencyStatus = "";
+		bufferbloatStatus = "";
         clientIp = "";
 		dlProgress = 0;
 		ulProgress = 0;
@@ -287,6 +312,43 @@ function clearRequests() {
 		}
 		xhr = null;
 	}
+	if (ws) {
+		try {
+			ws.onmessage = null;
+			ws.onclose = null;
+			ws.onerror = null;
+			ws.close();
+		} catch (e) {}
+		ws = null;
+	}
+}
+// requests a test session ID using url_session, then calls the done function. the test runs without session if this fails
+function getSession(done) {
+	tverb("getSession");
+	if (!settings.url_session) {
+		done();
+		return;
+	}
+	var r = new XMLHttpRequest();
+	r.onload = function() {
+		try {
+			sessionId = JSON.parse(r.responseText).session || null;
+		} catch (e) {
+			sessionId = null;
+		}
+		tlog("session: " + sessionId);
+		done();
+	};
+	r.onerror = function() {
+		tlog("getSession failed");
+		done();
+	};
+	r.open("GET", settings.url_session + url_sep(settings.url_session) + (settings.mpot ? "cors=true&" : "") + "r=" + Math.random(), true);
+	r.send();
+}
+// returns the session parameter for test requests, if there is a session
+function sessionParam() {
+	return sessionId ? "&session=" + encodeURIComponent(sessionId) : "";
 }
 // gets client's IP using url_getIp, then calls the done function
 var ipCalled = false; // used to prevent multiple accidental calls to getIp
@@ -373,7 +435,7 @@ function dlTest(done) {
 					if (settings.xhr_dlUseBlob) xhr[i].responseType = "blob";
 					else xhr[i].responseType = "arraybuffer";
 				} catch (e) {}
-				xhr[i].open("GET", settings.url_dl + url_sep(settings.url_dl) + (settings.mpot ? "cors=true&" : "") + "r=" + Math.random() + "&ckSize=" + settings.garbagePhp_chunkSize, true); // random string to prevent caching
+				xhr[i].open("GET", settings.url_dl + url_sep(settings.url_dl) + (settings.mpot ? "cors=true&" : "") + "r=" + Math.random() + "&ckSize=" + settings.garbagePhp_chunkSize + sessionParam(), true); // random string to prevent caching
 				xhr[i].send();
 			}.bind(this),
 			1 + delay
@@ -480,7 +542,7 @@ function ulTest(done) {
 							totLoaded += reqsmall.size;
 							testStream(i, 0);
 						};
-						xhr[i].open("POST", settings.url_ul + url_sep(settings.url_ul) + (settings.mpot ? "cors=true&" : "") + "r=" + Math.random(), true); // random string to prevent caching
+						xhr[i].open("POST", settings.url_ul + url_sep(settings.url_ul) + (settings.mpot ? "cors=true&" : "") + "r=" + Math.random() + sessionParam(), true); // random string to prevent caching
 						try {
 							xhr[i].setRequestHeader("Content-Encoding", "identity"); // disable compression (some browsers may refuse it, but data is incompressible anyway)
 						} catch (e) {}
@@ -516,7 +578,7 @@ function ulTest(done) {
 							if (settings.xhr_ignoreErrors === 1) testStream(i, 0); //restart stream
 						}.bind(this);
 						// send xhr
-						xhr[i].open("POST", settings.url_ul + url_sep(settings.url_ul) + (settings.mpot ? "cors=true&" : "") + "r=" + Math.random(), true); // random string to prevent caching
+						xhr[i].open("POST", settings.url_ul + url_sep(settings.url_ul) + (settings.mpot ? "cors=true&" : "") + "r=" + Math.random() + sessionParam(), true); // random string to prevent caching
 						try {
 							xhr[i].setRequestHeader("Content-Encoding", "identity"); // disable compression (some browsers may refuse it, but data is incompressible anyway)
 						} catch (e) {}
@@ -590,6 +652,143 @@ function pingTest(done) {
 	if (ptCalled) return;
 	else ptCalled = true; // pingTest already called?
 	var startT = new Date().getTime(); //when the test was started
+	wsPingTest(startT, done, function() {
+		xhrPingTest(startT, done);
+	});
+}
+// returns the ws:// or wss:// URL of url with query appended, or null if WebSockets can't be used
+function wsURL(url, query) {
+	if (!url || typeof WebSocket === "undefined") return null;
+	try {
+		var u = new URL(url, location.href);
+		u.protocol = u.protocol === "https:" ? "wss:" : "ws:";
+		u.search = (u.search ? u.search + "&" : "?") + query;
+		return u.toString();
+	} catch (e) {
+		return null;
+	}
+}
+// ping+jitter test measured by the server over a WebSocket. the server sends probes that are echoed back and reports the result, fallback is called if the server doesn't support it
+function wsPingTest(startT, done, fallback) {
+	var url = wsURL(settings.url_latency, "count=" + settings.count_ping + sessionParam());
+	if (!url) {
+		fallback();
+		return;
+	}
+	tverb("wsPingTest");
+	var received = 0;
+	var finished = false;
+	try {
+		ws = new WebSocket(url);
+	} catch (e) {
+		tlog("WebSocket ping test not available, using XHR");
+		fallback();
+		return;
+	}
+	ws.onmessage = function(e) {
+		var m;
+		try {
+			m = JSON.parse(e.data);
+		} catch (err) {
+			return;
+		}
+		if (m.type === "probe") {
+			ws.send(e.data);
+			received++;
+			pingProgress = received / settings.count_ping;
+		} else if (m.type === "result") {
+			finished = true;
+			pingStatus = m.ping.toFixed(2);
+			jitterStatus = m.jitter.toFixed(2);
+			pingProgress = 1;
+			tlog("ping: " + pingStatus + " jitter: " + jitterStatus + " loss: " + m.loss.toFixed(1) + "%, took " + (new Date().getTime() - startT) + "ms");
+			clearRequests();
+			done();
+		}
+	};
+	ws.onclose = function() {
+		// closed before the result, e.g. because the server doesn't have the endpoint
+		if (finished) return;
+		tlog("WebSocket ping test failed, using XHR");
+		clearRequests();
+		pingProgress = 0;
+		fallback();
+	};
+}
+// starts the loaded latency test if enabled. the server sends probes until stopLoadedLatency is called and attributes them to the download and upload streams of the session
+function startLoadedLatency() {
+	if (!settings.test_bufferbloat || !sessionId) return;
+	var url = wsURL(settings.url_loadedLatency, "r=" + Math.random() + sessionParam());
+	if (!url) {
+		tlog("loaded latency test not available");
+		return;
+	}
+	tverb("startLoadedLatency");
+	try {
+		loadedWs = new WebSocket(url);
+	} catch (e) {
+		tlog("loaded latency test failed: " + e);
+		loadedWs = null;
+		return;
+	}
+	loadedWs.onmessage = function(e) {
+		var m;
+		try {
+			m = JSON.parse(e.data);
+		} catch (err) {
+			return;
+		}
+		if (m.type === "probe") loadedWs.send(e.data);
+		else if (m.type === "result") {
+			dlLatencyStatus = m.downloadPing ? m.downloadPing.toFixed(2) : "";
+			ulLatencyStatus = m.uploadPing ? m.uploadPing.toFixed(2) : "";
+			bufferbloatStatus = m.grade;
+			tlog("loaded ping: download " + dlLatencyStatus + " upload " + ulLatencyStatus + " idle " + m.idlePing.toFixed(2) + " loss: " + m.loss.toFixed(1) + "% bufferbloat: " + bufferbloatStatus);
+		}
+	};
+	loadedWs.onclose = function() {
+		loadedWs = null;
+		if (loadedDone) {
+			var done = loadedDone;
+			loadedDone = null;
+			done();
+		}
+	};
+}
+// ends the loaded latency test, done is called once the server has sent the result or after a timeout
+function stopLoadedLatency(done) {
+	if (!loadedWs) {
+		done();
+		return;
+	}
+	tverb("stopLoadedLatency");
+	loadedDone = done;
+	try {
+		loadedWs.send(JSON.stringify({ type: "done" }));
+	} catch (e) {}
+	setTimeout(function() {
+		if (loadedDone) {
+			tlog("loaded latency test timed out");
+			closeLoadedLatency();
+		}
+	}, 3000);
+}
+// closes the loaded latency WebSocket, calling the pending done function if any
+function closeLoadedLatency() {
+	if (!loadedWs) return;
+	var done = loadedDone;
+	loadedDone = null;
+	try {
+		loadedWs.onmessage = null;
+		loadedWs.onclose = null;
+		loadedWs.close();
+	} catch (e) {}
+	loadedWs = null;
+	if (done) done();
+}
+// ping+jitter test timed by this worker with XHRs to url_ping
+function xhrPingTest(startT, done) {
+	tverb("xhrPingTest");
 	var prevT = null; // last time a pong was received
 	var ping = 0.0; // current ping value
 	var jitter = 0.0; // current jitter value
@@ -673,7 +872,7 @@ function pingTest(done) {
 			}
 		}.bind(this);
 		// send xhr
-		xhr[0].open("GET", settings.url_ping + url_sep(settings.url_ping) + (settings.mpot ? "cors=true&" : "") + "r=" + Math.random(), true); // random string to prevent caching
+		xhr[0].open("GET", settings.url_ping + url_sep(settings.url_ping) + (settings.mpot ? "cors=true&" : "") + "r=" + Math.random() + sessionParam(), true); // random string to prevent caching
 		xhr[0].send();
 	}.bind(this);
 	doPing(); // start first ping
@@ -701,7 +900,7 @@ function sendTelemetry(done) {
 		console.log("TELEMETRY ERROR " + xhr.status);
 		done(null);
 	};
-	xhr.open("POST", settings.url_telemetry + url_sep(settings.url_telemetry) + (settings.mpot ? "cors=true&" : "") + "r=" + Math.random(), true);
+	xhr.open("POST", settings.url_telemetry + url_sep(settings.url_telemetry) + (settings.mpot ? "cors=true&" : "") + "r=" + Math.random() + (settings.mpot ? "" : sessionParam()), true); // with multiple points of test, the session belongs to the test server, not to this one, so require_session can't be used on the server collecting the telemetry
 	var telemetryIspInfo = {
 		processedString: clientIp,
 		rawIspInfo: typeof ispInfo === "object" ? ispInfo : ""
@@ -0,0 +1,262 @@
+package web
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librespeed/speedtest/metrics"
+	"github.com/librespeed/speedtest/session"
+)
+
+const (
+	loadedProbeInterval = 250 * time.Millisecond
+	// the test ends by itself if the client doesn't finish it within this time
+	loadedMaxDuration = 5 * time.Minute
+
+	phaseIdle     = "idle"
+	phaseDownload = "download"
+	phaseUpload   = "upload"
+)
+
+// bufferbloatGrades rate the increase of the median ping under load over the idle ping in
+// milliseconds, larger increases are graded F
+var bufferbloatGrades = []struct {
+	increase float64
+	grade    string
+}{
+	{5, "A+"},
+	{30, "A"},
+	{60, "B"},
+	{200, "C"},
+	{400, "D"},
+}
+
+// loadedLatencyResult concludes the test
+type loadedLatencyResult struct {
+	Type         string                  `json:"type"`
+	IdlePing     float64                 `json:"idlePing"`
+	DownloadPing float64                 `json:"downloadPing"`
+	UploadPing   float64                 `json:"uploadPing"`
+	Loss         float64                 `json:"loss"`
+	Grade        string                  `json:"grade"`
+	Samples      []session.LatencySample `json:"samples"`
+}
+
+// loadedLatency measures latency while the download and upload tests of a session load
+// the link. Probes are sent until the client sends a done message, each one is attributed
+// to the streams of the session in progress when it was sent.
+func loadedLatency(w http.ResponseWriter, r *http.Request) {
+	metrics.ActiveStreams.WithLabelValues(metrics.StreamLoadedLatency).Inc()
+	defer metrics.ActiveStreams.WithLabelValues(metrics.StreamLoadedLatency).Dec()
+
+	sess, ok := testSession(w, r)
+	if !ok {
+		return
+	}
+	if sess == nil {
+		http.Error(w, "Loaded latency test requires a test session", http.StatusBadRequest)
+		return
+	}
+
+	conn, ok := upgrade(w, r)
+	if !ok {
+		return
+	}
+	defer release(conn)
+
+	samples, err := measureLoadedLatency(conn, sess)
+	if err != nil {
+		sess.Logger().Debugf("Loaded latency test failed: %s", err)
+		return
+	}
+
+	result := loadedLatencyStats(samples, sess)
+	sess.SetLoadedLatency(result)
+	sess.Logger().WithFields(log.Fields{
+		"idle_ping":     result.IdlePing,
+		"download_ping": result.DownloadPing,
+		"upload_ping":   result.UploadPing,
+		"loss":          result.Loss,
+		"grade":         result.Grade,
+	}).Debug("Loaded latency test finished")
+
+	_ = conn.WriteJSON(loadedLatencyResult{
+		Type:         "result",
+		IdlePing:     result.IdlePing,
+		DownloadPing: result.DownloadPing,
+		UploadPing:   result.UploadPing,
+		Loss:         result.Loss,
+		Grade:        result.Grade,
+		Samples:      result.Samples,
+	})
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+}
+
+// measureLoadedLatency sends probes every loadedProbeInterval until the client is done.
+// Probes that may still be answered at that point are left out of the samples.
+func measureLoadedLatency(conn *websocket.Conn, sess *session.Session) ([]session.LatencySample, error) {
+	var (
+		lock     sync.Mutex
+		start    = time.Now()
+		sent     []time.Time
+		answered []bool
+		samples  []session.LatencySample
+		done     = make(chan struct{})
+		readErr  = make(chan error, 1)
+	)
+
+	conn.SetReadLimit(1024)
+	_ = conn.SetReadDeadline(start.Add(loadedMaxDuration + latencyProbeTimeout))
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			now := time.Now()
+			if err != nil {
+				readErr <- err
+				return
+			}
+
+			var msg latencyProbe
+			if json.Unmarshal(data, &msg) != nil {
+				continue
+			}
+			if msg.Type == "done" {
+				close(done)
+				return
+			}
+			if msg.Type != "probe" {
+				continue
+			}
+
+			lock.Lock()
+			if msg.Seq >= 0 && msg.Seq < len(sent) && !answered[msg.Seq] {
+				answered[msg.Seq] = true
+				if rtt := now.Sub(sent[msg.Seq]); rtt <= latencyProbeTimeout {
+					samples[msg.Seq].RTT = milliseconds(rtt)
+				}
+			}
+			lock.Unlock()
+		}
+	}()
+
+	ticker := time.NewTicker(loadedProbeInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(loadedMaxDuration)
+	defer timeout.Stop()
+probes:
+	for seq := 0; ; seq++ {
+		phase := phaseIdle
+		if direction, ok := sess.Loading(); ok {
+			phase = phaseDownload
+			if direction == session.Upload {
+				phase = phaseUpload
+			}
+		}
+
+		now := time.Now()
+		lock.Lock()
+		sent = append(sent, now)
+		answered = append(answered, false)
+		samples = append(samples, session.LatencySample{Time: milliseconds(now.Sub(start)), Phase: phase})
+		lock.Unlock()
+		if err := conn.WriteJSON(latencyProbe{Type: "probe", Seq: seq}); err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			break probes
+		case <-timeout.C:
+			break probes
+		case err := <-readErr:
+			return nil, err
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	finished := make([]session.LatencySample, 0, len(samples))
+	for i, sample := range samples {
+		if answered[i] || time.Since(sent[i]) > latencyProbeTimeout {
+			finished = append(finished, sample)
+		}
+	}
+	return finished, nil
+}
+
+// loadedLatencyStats calculates the median ping of each phase and grades the bufferbloat.
+// The idle ping falls back to the ping test of the session if no probe was sent between
+// the download and upload tests.
+func loadedLatencyStats(samples []session.LatencySample, sess *session.Session) session.LoadedLatency {
+	l := session.LoadedLatency{Samples: samples}
+	rtts := make(map[string][]float64)
+	var loaded, lost int
+	for _, sample := range samples {
+		if sample.Phase != phaseIdle {
+			loaded++
+			if sample.RTT == 0 {
+				lost++
+			}
+		}
+		if sample.RTT > 0 {
+			rtts[sample.Phase] = append(rtts[sample.Phase], sample.RTT)
+		}
+	}
+
+	l.IdlePing = median(rtts[phaseIdle])
+	if idle, ok := sess.Latency(); ok && l.IdlePing == 0 {
+		l.IdlePing = idle.Ping
+	}
+	l.DownloadPing = median(rtts[phaseDownload])
+	l.UploadPing = median(rtts[phaseUpload])
+	if loaded > 0 {
+		l.Loss = float64(lost) / float64(loaded) * 100
+	}
+	l.Grade = bufferbloatGrade(l)
+	return l
+}
+
+// bufferbloatGrade rates the worse of the download and upload pings, it is empty if either
+// the idle or both loaded pings are missing
+func bufferbloatGrade(l session.LoadedLatency) string {
+	loaded := math.Max(l.DownloadPing, l.UploadPing)
+	if l.IdlePing == 0 || loaded == 0 {
+		return ""
+	}
+
+	increase := loaded - l.IdlePing
+	for _, g := range bufferbloatGrades {
+		if increase < g.increase {
+			return g.grade
+		}
+	}
+	return "F"
+}
+
+// median returns the median of values, or 0 if there are none
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
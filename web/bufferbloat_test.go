@@ -0,0 +1,127 @@
+package web
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest/session"
+)
+
+func TestBufferbloatGrade(t *testing.T) {
+	tests := []struct {
+		idle, download, upload float64
+		want                   string
+	}{
+		{10, 14.9, 0, "A+"},
+		{10, 15, 0, "A"},
+		{10, 39.9, 0, "A"},
+		{10, 40, 0, "B"},
+		{10, 70, 0, "C"},
+		{10, 209.9, 0, "C"},
+		{10, 210, 0, "D"},
+		{10, 410, 0, "F"},
+		// the worse direction counts
+		{10, 12, 70, "C"},
+		{10, 0, 12, "A+"},
+		// loaded pings below the idle ping are no bufferbloat
+		{20, 10, 10, "A+"},
+		{0, 50, 50, ""},
+		{10, 0, 0, ""},
+	}
+	for _, tt := range tests {
+		l := session.LoadedLatency{IdlePing: tt.idle, DownloadPing: tt.download, UploadPing: tt.upload}
+		if got := bufferbloatGrade(l); got != tt.want {
+			t.Errorf("bufferbloatGrade(idle %v, download %v, upload %v) = %q, want %q", tt.idle, tt.download, tt.upload, got, tt.want)
+		}
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   float64
+	}{
+		{nil, 0},
+		{[]float64{7}, 7},
+		{[]float64{9, 1, 5}, 5},
+		{[]float64{4, 1, 3, 2}, 2.5},
+	}
+	for _, tt := range tests {
+		if got := median(tt.values); got != tt.want {
+			t.Errorf("median(%v) = %v, want %v", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name string
+		rtts []time.Duration
+		want session.Latency
+	}{
+		{"no probes", nil, session.Latency{}},
+		{"single probe", []time.Duration{12 * ms}, session.Latency{Ping: 12, Sent: 1, Received: 1}},
+		{"single lost probe", []time.Duration{0}, session.Latency{Loss: 100, Sent: 1}},
+		{"lost probes are skipped", []time.Duration{10 * ms, 0, 30 * ms, 0}, session.Latency{Ping: 10, Jitter: 20, Loss: 50, Sent: 4, Received: 2}},
+		{"jitter smoothing", []time.Duration{10 * ms, 20 * ms, 15 * ms}, session.Latency{Ping: 10, Jitter: 9, Sent: 3, Received: 3}},
+	}
+	for _, tt := range tests {
+		got := latencyStats(tt.rtts)
+		if got.Sent != tt.want.Sent || got.Received != tt.want.Received ||
+			!near(got.Ping, tt.want.Ping) || !near(got.Jitter, tt.want.Jitter) || !near(got.Loss, tt.want.Loss) {
+			t.Errorf("%s: latencyStats() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadedLatencyStats(t *testing.T) {
+	tests := []struct {
+		name     string
+		idlePing float64
+		samples  []session.LatencySample
+		want     session.LoadedLatency
+	}{
+		{"no samples", 0, nil, session.LoadedLatency{}},
+		{"no samples with ping test", 12, nil, session.LoadedLatency{IdlePing: 12}},
+		{"single loaded sample", 10, []session.LatencySample{
+			{Phase: phaseDownload, RTT: 50},
+		}, session.LoadedLatency{IdlePing: 10, DownloadPing: 50, Grade: "B"}},
+		{"single lost sample", 10, []session.LatencySample{
+			{Phase: phaseUpload},
+		}, session.LoadedLatency{IdlePing: 10, Loss: 100}},
+		{"idle probes take precedence over the ping test", 30, []session.LatencySample{
+			{Phase: phaseIdle, RTT: 10},
+			{Phase: phaseIdle, RTT: 12},
+			{Phase: phaseIdle},
+			{Phase: phaseDownload, RTT: 40},
+			{Phase: phaseDownload},
+			{Phase: phaseUpload, RTT: 100},
+		}, session.LoadedLatency{IdlePing: 11, DownloadPing: 40, UploadPing: 100, Loss: 100.0 / 3, Grade: "C"}},
+	}
+	for i, tt := range tests {
+		sess, err := session.New("192.0.2." + strconv.Itoa(i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.idlePing > 0 {
+			sess.SetLatency(session.Latency{Ping: tt.idlePing, Sent: 1, Received: 1})
+		}
+
+		got := loadedLatencyStats(tt.samples, sess)
+		session.Remove(sess.ID)
+		if !near(got.IdlePing, tt.want.IdlePing) || !near(got.DownloadPing, tt.want.DownloadPing) ||
+			!near(got.UploadPing, tt.want.UploadPing) || !near(got.Loss, tt.want.Loss) || got.Grade != tt.want.Grade {
+			t.Errorf("%s: loadedLatencyStats() = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if len(got.Samples) != len(tt.samples) {
+			t.Errorf("%s: %d samples, want %d", tt.name, len(got.Samples), len(tt.samples))
+		}
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
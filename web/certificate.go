@@ -0,0 +1,133 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// certificate files are checked for changes this often, SIGHUP reloads them immediately
+	certificateReloadInterval = 30 * time.Second
+)
+
+// certificateReloader serves a certificate and key pair from disk and swaps it when the
+// files change, so that renewed certificates are used without restarting the server
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	lock    sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	c := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go c.watch(hup)
+
+	return c, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.cert, nil
+}
+
+func (c *certificateReloader) watch(hup <-chan os.Signal) {
+	ticker := time.NewTicker(certificateReloadInterval)
+	for {
+		select {
+		case <-hup:
+			log.Infof("Received SIGHUP, reloading TLS certificate")
+		case <-ticker.C:
+			if !c.changed() {
+				continue
+			}
+		}
+
+		if err := c.reload(); err != nil {
+			log.Errorf("Cannot reload TLS certificate, keeping the previous one: %s", err)
+		}
+	}
+}
+
+// changed reports whether the certificate or key file was modified since the last attempt to load them
+func (c *certificateReloader) changed() bool {
+	certMod, keyMod, err := c.modTimes()
+	if err != nil {
+		return false
+	}
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return !certMod.Equal(c.certMod) || !keyMod.Equal(c.keyMod)
+}
+
+func (c *certificateReloader) modTimes() (time.Time, time.Time, error) {
+	cert, err := os.Stat(c.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	key, err := os.Stat(c.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return cert.ModTime(), key.ModTime(), nil
+}
+
+// reload loads the key pair, keeping the current one if the files are broken or don't
+// match, e.g. because only one of them has been replaced yet
+func (c *certificateReloader) reload() error {
+	certMod, keyMod, err := c.modTimes()
+	if err != nil {
+		return fmt.Errorf("cannot load TLS certificate: %w", err)
+	}
+
+	// retry only once the files change again
+	c.lock.Lock()
+	c.certMod, c.keyMod = certMod, keyMod
+	c.lock.Unlock()
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load TLS certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("cannot parse TLS certificate: %w", err)
+	}
+	cert.Leaf = leaf
+
+	c.lock.Lock()
+	c.cert = &cert
+	c.lock.Unlock()
+
+	name := leaf.Subject.CommonName
+	if len(leaf.DNSNames) > 0 {
+		name = strings.Join(leaf.DNSNames, ", ")
+	}
+	log.Infof("Loaded TLS certificate for %s from %s, valid until %s", name, c.certFile,
+		leaf.NotAfter.UTC().Format(time.RFC3339))
+	if time.Until(leaf.NotAfter) < 0 {
+		log.Warnf("TLS certificate from %s has expired", c.certFile)
+	}
+	return nil
+}
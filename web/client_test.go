@@ -0,0 +1,89 @@
+package web
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest/client"
+	"github.com/librespeed/speedtest/config"
+	"github.com/librespeed/speedtest/database"
+	"github.com/librespeed/speedtest/results"
+)
+
+// staticProvider answers every lookup with the same ISP, so that the test doesn't query
+// ipinfo.io
+type staticProvider struct{}
+
+func (staticProvider) Lookup(addr string) (results.IPInfoResponse, bool, error) {
+	return results.IPInfoResponse{IP: addr, Organization: "AS64496 Example ISP"}, true, nil
+}
+
+// TestClientRun runs the command line client against the routes of the server
+func TestClientRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a speed test")
+	}
+
+	settings := filepath.Join(t.TempDir(), "settings.toml")
+	if err := os.WriteFile(settings, []byte("database_type=\"memory\"\nrequire_session=true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	conf := config.Load(settings)
+	ispProvider = staticProvider{}
+	results.Initialize(&conf)
+	database.SetDBInfo(&conf)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(newRouter(ctx, &conf))
+	defer srv.Close()
+
+	opts := client.DefaultOptions()
+	opts.Server = srv.URL
+	opts.DownloadStreams, opts.UploadStreams = 2, 2
+	opts.DownloadDuration, opts.UploadDuration = 500*time.Millisecond, 500*time.Millisecond
+	opts.DownloadGraceTime, opts.UploadGraceTime = 100*time.Millisecond, 100*time.Millisecond
+	opts.ChunkSize, opts.UploadSize = 1, 1
+	opts.Pings = 3
+	opts.Telemetry = true
+	c, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := c.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Session == "" {
+		t.Error("no test session was issued")
+	}
+	if !strings.HasPrefix(res.IP, "127.0.0.1") {
+		t.Errorf("IP = %q", res.IP)
+	}
+	if res.Download <= 0 || res.DownloadBytes <= 0 {
+		t.Errorf("download = %v Mbit/s, %d bytes", res.Download, res.DownloadBytes)
+	}
+	if res.Upload <= 0 || res.UploadBytes <= 0 {
+		t.Errorf("upload = %v Mbit/s, %d bytes", res.Upload, res.UploadBytes)
+	}
+	if res.Ping <= 0 {
+		t.Errorf("ping = %v", res.Ping)
+	}
+	if res.ID == "" {
+		t.Fatal("telemetry returned no test ID")
+	}
+
+	stored, err := database.DB.FetchByUUID(res.ID)
+	if err != nil {
+		t.Fatalf("telemetry of %s was not stored: %s", res.ID, err)
+	}
+	if stored.Download <= 0 || stored.Upload <= 0 {
+		t.Errorf("stored result = %+v", stored)
+	}
+}
@@ -0,0 +1,202 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librespeed/speedtest/config"
+	"github.com/librespeed/speedtest/database"
+	"github.com/librespeed/speedtest/ispinfo"
+)
+
+const (
+	// remote ISP info services are checked this often in the background for readiness reports
+	ispCheckInterval = time.Minute
+	// a database that doesn't answer within this time is reported as unavailable
+	databasePingTimeout = 2 * time.Second
+)
+
+// Version is set at build time with -ldflags "-X github.com/librespeed/speedtest/web.Version=..."
+var Version = ""
+
+// healthz reports that the process is alive
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// readiness checks whether the server can run tests and store their results
+type readiness struct {
+	assets http.FileSystem
+
+	lock sync.RWMutex
+	// ispStatus is the result of the last check of the ISP info provider
+	ispStatus string
+}
+
+// newReadiness checks the ISP info provider in the background until ctx is cancelled, so
+// that probes neither wait for remote services nor use up their quota
+func newReadiness(ctx context.Context, assets http.FileSystem) *readiness {
+	rd := &readiness{assets: assets, ispStatus: "pending"}
+	go rd.watchISPProvider(ctx)
+	return rd
+}
+
+func (rd *readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), databasePingTimeout)
+	defer cancel()
+	checks := map[string]error{
+		"database": database.DB.Ping(ctx),
+		"assets":   rd.checkAssets(),
+	}
+
+	resp := readinessResponse{Status: "ok", Checks: make(map[string]string)}
+	status := http.StatusOK
+	for name, err := range checks {
+		if err != nil {
+			log.Warnf("Readiness check %s failed: %s", name, err)
+			resp.Checks[name] = err.Error()
+			resp.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		resp.Checks[name] = "ok"
+	}
+
+	// tests still run without ISP info, so a failing provider is reported but keeps the
+	// server ready
+	rd.lock.RLock()
+	resp.Checks["isp_provider"] = rd.ispStatus
+	rd.lock.RUnlock()
+
+	render.Status(r, status)
+	render.JSON(w, r, resp)
+}
+
+func (rd *readiness) checkAssets() error {
+	f, err := rd.assets.Open("/")
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (rd *readiness) watchISPProvider(ctx context.Context) {
+	ticker := time.NewTicker(ispCheckInterval)
+	defer ticker.Stop()
+	for {
+		rd.checkISPProvider()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkISPProvider updates the ISP provider status, failures are logged when they change
+func (rd *readiness) checkISPProvider() {
+	status := "ok"
+	if err := ispinfo.Check(ispProvider); err != nil {
+		status = err.Error()
+	}
+
+	rd.lock.Lock()
+	defer rd.lock.Unlock()
+	if status != "ok" && status != rd.ispStatus {
+		log.Warnf("ISP info provider check failed: %s", status)
+	}
+	rd.ispStatus = status
+}
+
+type versionResponse struct {
+	Version     string   `json:"version"`
+	Revision    string   `json:"revision,omitempty"`
+	BuildTime   string   `json:"build_time,omitempty"`
+	Modified    bool     `json:"modified,omitempty"`
+	GoVersion   string   `json:"go_version"`
+	Database    string   `json:"database"`
+	ISPProvider string   `json:"isp_provider"`
+	Features    []string `json:"features"`
+}
+
+// versionHandler reports the build and the enabled features
+func versionHandler(conf *config.Config, rateLimited bool) http.HandlerFunc {
+	resp := versionResponse{
+		Version:     Version,
+		GoVersion:   runtime.Version(),
+		Database:    conf.DatabaseType,
+		ISPProvider: ispinfo.ProviderName(conf),
+		Features:    features(conf, rateLimited),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if resp.Version == "" {
+			resp.Version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				resp.Revision = setting.Value
+			case "vcs.time":
+				resp.BuildTime = setting.Value
+			case "vcs.modified":
+				resp.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, resp)
+	}
+}
+
+func features(conf *config.Config, rateLimited bool) []string {
+	listeners := conf.Listeners
+	if len(listeners) == 0 {
+		listeners = []config.Listener{{TLS: conf.EnableTLS, HTTP2: conf.EnableHTTP2, HTTP3: conf.EnableHTTP3}}
+		if conf.ProxyProtocolPort != "0" {
+			listeners = append(listeners, config.Listener{ProxyProtocol: true})
+		}
+	}
+	var tls, http2, http3, proxyProtocol bool
+	for _, lc := range listeners {
+		tls = tls || lc.TLS
+		http2 = http2 || lc.TLS && lc.HTTP2
+		http3 = http3 || lc.TLS && lc.HTTP3
+		proxyProtocol = proxyProtocol || lc.ProxyProtocol
+	}
+
+	enabled := []string{}
+	for _, f := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"tls", tls},
+		{"http2", http2},
+		{"http3", http3},
+		{"proxy_protocol", proxyProtocol},
+		{"acme", conf.ACME},
+		{"metrics", conf.EnableMetrics},
+		{"rate_limit", rateLimited},
+		{"require_session", conf.RequireSession},
+		{"redact_ip_addresses", conf.RedactIP},
+		{"sites", conf.SiteMappingFile != ""},
+	} {
+		if f.enabled {
+			enabled = append(enabled, f.name)
+		}
+	}
+	return enabled
+}
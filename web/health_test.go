@@ -0,0 +1,74 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest/database"
+	"github.com/librespeed/speedtest/database/memory"
+	"github.com/librespeed/speedtest/results"
+)
+
+// checkedProvider is a remote provider whose checks are counted and fail with err
+type checkedProvider struct {
+	checks chan struct{}
+	err    error
+}
+
+func (p *checkedProvider) Lookup(addr string) (results.IPInfoResponse, bool, error) {
+	return results.IPInfoResponse{IP: addr}, false, nil
+}
+
+func (p *checkedProvider) Check() error {
+	p.checks <- struct{}{}
+	return p.err
+}
+
+func TestReadinessReportsCachedISPStatus(t *testing.T) {
+	previousDB, previousProvider := database.DB, ispProvider
+	defer func() { database.DB, ispProvider = previousDB, previousProvider }()
+	database.DB = memory.Open(1)
+	provider := &checkedProvider{checks: make(chan struct{}, 10), err: errors.New("quota exceeded")}
+	ispProvider = provider
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rd := newReadiness(ctx, http.Dir(t.TempDir()))
+	select {
+	case <-provider.checks:
+	case <-time.After(time.Second):
+		t.Fatal("ISP provider was not checked")
+	}
+
+	// wait for the check result to be stored
+	var resp readinessResponse
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		w := httptest.NewRecorder()
+		rd.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("readiness answered %d with a failing ISP provider, want 200", w.Code)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Checks["isp_provider"] != "pending" || time.Now().After(deadline) {
+			break
+		}
+	}
+	if resp.Status != "ok" || resp.Checks["isp_provider"] != "quota exceeded" || resp.Checks["database"] != "ok" {
+		t.Errorf("readiness = %+v", resp)
+	}
+
+	// requests don't check the provider themselves
+	for i := 0; i < 3; i++ {
+		rd.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	}
+	if n := len(provider.checks); n != 0 {
+		t.Errorf("readiness requests checked the provider %d times", n)
+	}
+}
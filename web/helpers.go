@@ -2,9 +2,8 @@ package web
 
 import (
 	"crypto/rand"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -13,11 +12,14 @@ import (
 	"github.com/umahmood/haversine"
 
 	"github.com/librespeed/speedtest/config"
+	"github.com/librespeed/speedtest/ispinfo"
+	"github.com/librespeed/speedtest/ispinfo/ipinfo"
 	"github.com/librespeed/speedtest/results"
 )
 
 var (
 	serverCoord haversine.Coord
+	ispProvider ispinfo.Provider
 )
 
 func getRandomData(length int) []byte {
@@ -28,43 +30,30 @@ func getRandomData(length int) []byte {
 	return data
 }
 
-func getIPInfoURL(address string) string {
-	apiKey := config.LoadedConfig().IPInfoAPIKey
-
-	ipInfoURL := `https://ipinfo.io/%s/json`
-	if address != "" {
-		ipInfoURL = fmt.Sprintf(ipInfoURL, address)
-	} else {
-		ipInfoURL = "https://ipinfo.io/json"
-	}
-
-	if apiKey != "" {
-		ipInfoURL += "?token=" + apiKey
+// clientIP returns the client address without port. middleware.RealIP replaces
+// RemoteAddr with a bare IP address when the request comes through a proxy.
+func clientIP(r *http.Request) string {
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return ip
 	}
-
-	return ipInfoURL
+	return r.RemoteAddr
 }
 
-func getIPInfo(addr string) results.IPInfoResponse {
-	var ret results.IPInfoResponse
-	resp, err := http.DefaultClient.Get(getIPInfoURL(addr))
+// InitISPInfo sets up the ISP info provider selected in the configuration
+func InitISPInfo(conf *config.Config) {
+	p, err := ispinfo.New(conf)
 	if err != nil {
-		log.Errorf("Error getting response from ipinfo.io: %s", err)
-		return ret
+		log.Fatalf("Error setting up ISP info provider: %s", err)
 	}
+	ispProvider = p
+}
 
-	raw, err := ioutil.ReadAll(resp.Body)
+func getIPInfo(addr string) results.IPInfoResponse {
+	info, _, err := ispProvider.Lookup(addr)
 	if err != nil {
-		log.Errorf("Error reading response from ipinfo.io: %s", err)
-		return ret
+		log.Errorf("Error looking up ISP info for %s: %s", addr, err)
 	}
-	defer resp.Body.Close()
-
-	if err := json.Unmarshal(raw, &ret); err != nil {
-		log.Errorf("Error parsing response from ipinfo.io: %s", err)
-	}
-
-	return ret
+	return info
 }
 
 func SetServerLocation(conf *config.Config) {
@@ -75,21 +64,14 @@ func SetServerLocation(conf *config.Config) {
 		return
 	}
 
-	var ret results.IPInfoResponse
-	resp, err := http.DefaultClient.Get(getIPInfoURL(""))
-	if err != nil {
-		log.Errorf("Error getting repsonse from ipinfo.io: %s", err)
+	if !ispinfo.UsesIPInfo(conf) {
+		log.Warnf("Server coordinates are not configured and ipinfo.io is not used, set server_lat and server_lng for distances to be shown")
 		return
 	}
-	raw, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Errorf("Error reading response from ipinfo.io: %s", err)
-		return
-	}
-	defer resp.Body.Close()
 
-	if err := json.Unmarshal(raw, &ret); err != nil {
-		log.Errorf("Error parsing response from ipinfo.io: %s", err)
+	ret, _, err := ipinfo.New(conf.IPInfoAPIKey, conf.ISPLookupTimeout).Lookup("")
+	if err != nil {
+		log.Errorf("Cannot get server location: %s", err)
 		return
 	}
 
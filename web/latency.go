@@ -0,0 +1,212 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librespeed/speedtest/metrics"
+	"github.com/librespeed/speedtest/pingstats"
+	"github.com/librespeed/speedtest/session"
+)
+
+const (
+	latencyDefaultProbes = 10
+	latencyMaxProbes     = 100
+	latencyProbeInterval = 100 * time.Millisecond
+	// probes answered later than this count as lost
+	latencyProbeTimeout = 2 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	// multi-server frontends open the connection cross-origin, like CORS for the other endpoints
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// upgrade opens a WebSocket that is closed on shutdown, the caller must pass it to release
+// when done. Upgrade replies with an error itself.
+func upgrade(w http.ResponseWriter, r *http.Request) (*websocket.Conn, bool) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, false
+	}
+	if !websockets.add(conn) {
+		_ = conn.Close()
+		return nil, false
+	}
+	return conn, true
+}
+
+// release closes a WebSocket opened by upgrade
+func release(conn *websocket.Conn) {
+	websockets.remove(conn)
+	_ = conn.Close()
+}
+
+// latencyProbe is echoed back unchanged by the client
+type latencyProbe struct {
+	Type string `json:"type"`
+	Seq  int    `json:"seq"`
+}
+
+// latencyResult concludes the test
+type latencyResult struct {
+	Type     string  `json:"type"`
+	Ping     float64 `json:"ping"`
+	Jitter   float64 `json:"jitter"`
+	Loss     float64 `json:"loss"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+}
+
+// latency measures ping, jitter and packet loss over a WebSocket. The server sends count
+// probes and times their echoes itself, so the result doesn't depend on the browser.
+func latency(w http.ResponseWriter, r *http.Request) {
+	metrics.ActiveStreams.WithLabelValues(metrics.StreamLatency).Inc()
+	defer metrics.ActiveStreams.WithLabelValues(metrics.StreamLatency).Dec()
+
+	sess, ok := testSession(w, r)
+	if !ok {
+		return
+	}
+
+	probes := latencyDefaultProbes
+	if count := r.FormValue("count"); count != "" {
+		i, err := strconv.Atoi(count)
+		if err != nil || i < 1 {
+			http.Error(w, "Invalid probe count", http.StatusBadRequest)
+			return
+		}
+		if i > latencyMaxProbes {
+			i = latencyMaxProbes
+		}
+		probes = i
+	}
+
+	conn, ok := upgrade(w, r)
+	if !ok {
+		return
+	}
+	defer release(conn)
+
+	result, err := measureLatency(conn, probes)
+	if err != nil {
+		log.WithField("ip", clientIP(r)).Debugf("Latency test failed: %s", err)
+		return
+	}
+
+	if sess != nil {
+		sess.SetLatency(result)
+		sess.Logger().WithFields(log.Fields{
+			"ping":   result.Ping,
+			"jitter": result.Jitter,
+			"loss":   result.Loss,
+		}).Debug("Latency test finished")
+	}
+
+	_ = conn.WriteJSON(latencyResult{
+		Type:     "result",
+		Ping:     result.Ping,
+		Jitter:   result.Jitter,
+		Loss:     result.Loss,
+		Sent:     result.Sent,
+		Received: result.Received,
+	})
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+}
+
+// measureLatency sends probes every latencyProbeInterval and waits for the outstanding
+// echoes after the last one
+func measureLatency(conn *websocket.Conn, probes int) (session.Latency, error) {
+	var (
+		lock     sync.Mutex
+		sent     = make([]time.Time, probes)
+		rtts     = make([]time.Duration, probes)
+		received = make([]bool, probes)
+		pending  = probes
+		answered = make(chan struct{})
+		readErr  = make(chan error, 1)
+	)
+
+	conn.SetReadLimit(1024)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Duration(probes)*latencyProbeInterval + 2*latencyProbeTimeout))
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			now := time.Now()
+			if err != nil {
+				readErr <- err
+				return
+			}
+
+			var echo latencyProbe
+			if json.Unmarshal(data, &echo) != nil || echo.Type != "probe" || echo.Seq < 0 || echo.Seq >= probes {
+				continue
+			}
+
+			lock.Lock()
+			if !sent[echo.Seq].IsZero() && !received[echo.Seq] {
+				received[echo.Seq] = true
+				if rtt := now.Sub(sent[echo.Seq]); rtt <= latencyProbeTimeout {
+					rtts[echo.Seq] = rtt
+				}
+				pending--
+				if pending == 0 {
+					close(answered)
+				}
+			}
+			lock.Unlock()
+		}
+	}()
+
+	ticker := time.NewTicker(latencyProbeInterval)
+	defer ticker.Stop()
+	for seq := 0; seq < probes; seq++ {
+		lock.Lock()
+		sent[seq] = time.Now()
+		lock.Unlock()
+		if err := conn.WriteJSON(latencyProbe{Type: "probe", Seq: seq}); err != nil {
+			return session.Latency{}, err
+		}
+
+		select {
+		case <-ticker.C:
+		case err := <-readErr:
+			return session.Latency{}, err
+		}
+	}
+
+	select {
+	case <-answered:
+	case <-time.After(latencyProbeTimeout):
+	case err := <-readErr:
+		return session.Latency{}, err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	return latencyStats(rtts), nil
+}
+
+// latencyStats summarizes the round trip times of the probes, lost probes have none
+func latencyStats(rtts []time.Duration) session.Latency {
+	l := session.Latency{Sent: len(rtts)}
+	ms := make([]float64, len(rtts))
+	for i, rtt := range rtts {
+		if rtt > 0 {
+			ms[i] = milliseconds(rtt)
+			l.Received++
+		}
+	}
+	l.Ping, l.Jitter = pingstats.Compute(ms)
+	if l.Sent > 0 {
+		l.Loss = float64(l.Sent-l.Received) / float64(l.Sent) * 100
+	}
+	return l
+}
@@ -0,0 +1,132 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/librespeed/speedtest/config"
+	"github.com/librespeed/speedtest/session"
+)
+
+// newLatencyServer serves the latency test with require_session set
+func newLatencyServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	settings := filepath.Join(t.TempDir(), "settings.toml")
+	if err := os.WriteFile(settings, []byte("database_type=\"memory\"\nrequire_session=true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config.Load(settings)
+
+	srv := httptest.NewServer(http.HandlerFunc(latency))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// runLatencyTest echoes the probes of the server, except those for which drop returns
+// true, and returns the result
+func runLatencyTest(t *testing.T, url string, drop func(seq int) bool) latencyResult {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for {
+		var msg struct {
+			latencyResult
+			Seq int `json:"seq"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("no result: %s", err)
+		}
+		switch msg.Type {
+		case "probe":
+			if !drop(msg.Seq) {
+				if err := conn.WriteJSON(latencyProbe{Type: "probe", Seq: msg.Seq}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		case "result":
+			return msg.latencyResult
+		default:
+			t.Fatalf("unexpected message type %q", msg.Type)
+		}
+	}
+}
+
+func TestLatency(t *testing.T) {
+	srv := newLatencyServer(t)
+	sess, err := session.New("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Remove(sess.ID)
+
+	result := runLatencyTest(t, srv.URL+"/latency?count=3&session="+sess.ID, func(int) bool { return false })
+	if result.Sent != 3 || result.Received != 3 || result.Loss != 0 {
+		t.Errorf("result = %+v, want 3 of 3 probes answered", result)
+	}
+	if result.Ping <= 0 || result.Ping > float64(latencyProbeTimeout.Milliseconds()) {
+		t.Errorf("ping = %v", result.Ping)
+	}
+
+	stored, ok := sess.Latency()
+	if !ok || stored.Ping != result.Ping || stored.Jitter != result.Jitter || stored.Received != 3 {
+		t.Errorf("session latency = %+v, %v, want %+v", stored, ok, result)
+	}
+}
+
+func TestLatencyLoss(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the probe timeout")
+	}
+
+	srv := newLatencyServer(t)
+	sess, err := session.New("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Remove(sess.ID)
+
+	result := runLatencyTest(t, srv.URL+"/latency?count=4&session="+sess.ID, func(seq int) bool { return seq == 1 })
+	if result.Sent != 4 || result.Received != 3 || result.Loss != 25 {
+		t.Errorf("result = %+v, want 3 of 4 probes answered", result)
+	}
+}
+
+func TestLatencyRejectsRequests(t *testing.T) {
+	srv := newLatencyServer(t)
+	sess, err := session.New("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Remove(sess.ID)
+
+	tests := []struct {
+		name  string
+		query string
+		code  int
+	}{
+		{"missing session", "count=3", http.StatusForbidden},
+		{"unknown session", "session=unknown", http.StatusForbidden},
+		{"invalid count", "count=0&session=" + sess.ID, http.StatusBadRequest},
+		{"not a WebSocket", "count=3&session=" + sess.ID, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(srv.URL + "/latency?" + tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.code)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package web
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librespeed/speedtest/config"
+	"github.com/librespeed/speedtest/metrics"
+)
+
+// metricsHandler serves the metrics on the public listeners, to clients that send the
+// statistics password as HTTP basic auth password
+func metricsHandler(conf *config.Config) http.HandlerFunc {
+	h := metrics.Handler()
+	return func(w http.ResponseWriter, r *http.Request) {
+		if conf.StatsPassword == "PASSWORD" {
+			http.Error(w, "Please set statistics_password in settings.toml or use metrics_listen to enable access", http.StatusForbidden)
+			return
+		}
+		_, password, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(conf.StatsPassword)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="LibreSpeed metrics"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+// startMetricsServer serves the metrics without authentication on a separate address,
+// meant to be reachable only from the monitoring system
+func startMetricsServer(addr string, running *servers) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	srv := &http.Server{Handler: mux}
+	log.Infof("Serving Prometheus metrics on %s/metrics", l.Addr())
+	running.start(srv, func() error {
+		return srv.Serve(l)
+	})
+	return nil
+}
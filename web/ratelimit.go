@@ -0,0 +1,135 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librespeed/speedtest/config"
+	"github.com/librespeed/speedtest/metrics"
+	"github.com/librespeed/speedtest/ratelimit"
+)
+
+// newLimiter sets up the configured limits, the limiter is closed when ctx is cancelled
+func newLimiter(ctx context.Context, conf *config.Config) *ratelimit.Limiter {
+	perIP := ratelimit.Limits{
+		Streams:           conf.RateLimitIPStreams,
+		BytesPerMinute:    conf.RateLimitIPMiBPerMinute * 1048576,
+		RequestsPerSecond: conf.RateLimitIPRequestsPerSecond,
+	}
+	global := ratelimit.Limits{
+		Streams:           conf.RateLimitGlobalStreams,
+		BytesPerMinute:    conf.RateLimitGlobalMiBPerMinute * 1048576,
+		RequestsPerSecond: conf.RateLimitGlobalRequestsPerSecond,
+	}
+
+	limiter := ratelimit.New(perIP, global)
+	if limiter != nil {
+		log.Infof("Rate limiting test streams, per IP: %+v, global: %+v", perIP, global)
+		go func() {
+			<-ctx.Done()
+			limiter.Close()
+		}()
+	}
+	return limiter
+}
+
+// limitStreams admits download and upload streams through the limiter, and charges the
+// bytes transferred by the handler. The client address is taken after middleware.RealIP
+// and the proxy protocol listener have replaced it.
+func limitStreams(limiter *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(r)
+			stream, err := limiter.Open(ip)
+			if err != nil {
+				var limitErr *ratelimit.Error
+				errors.As(err, &limitErr)
+				metrics.RateLimited.WithLabelValues(limitErr.Scope, limitErr.Limit).Inc()
+				log.Debugf("Refusing stream from %s: %s", ip, err)
+
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limitErr.RetryAfter.Seconds()))))
+				http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+				return
+			}
+			if stream == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			defer stream.Close()
+
+			l := &limitedStream{stream: stream, ip: ip}
+			r.Body = &limitedBody{ReadCloser: r.Body, limitedStream: l}
+			next.ServeHTTP(&limitedResponseWriter{ResponseWriter: w, limitedStream: l}, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+type limitedStream struct {
+	stream   *ratelimit.Stream
+	ip       string
+	exceeded bool
+}
+
+func (l *limitedStream) transfer(n int) error {
+	if l.exceeded {
+		return nil
+	}
+
+	err := l.stream.Transfer(int64(n))
+	if err != nil {
+		l.exceeded = true
+		var limitErr *ratelimit.Error
+		errors.As(err, &limitErr)
+		metrics.RateLimited.WithLabelValues(limitErr.Scope, limitErr.Limit).Inc()
+		log.Debugf("Cutting stream from %s short: %s", l.ip, err)
+	}
+	return err
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	*limitedStream
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if limitErr := b.transfer(n); limitErr != nil {
+			return n, limitErr
+		}
+	}
+	return n, err
+}
+
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	*limitedStream
+}
+
+func (w *limitedResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	if err == nil {
+		err = w.transfer(n)
+	}
+	return n, err
+}
+
+// Hijack lets WebSocket latency tests take over the connection, their traffic isn't charged
+func (w *limitedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// isRateLimited reports whether err was caused by an exceeded limit
+func isRateLimited(err error) bool {
+	var limitErr *ratelimit.Error
+	return errors.As(err, &limitErr)
+}
@@ -0,0 +1,407 @@
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pires/go-proxyproto"
+	"github.com/quic-go/quic-go/http3"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librespeed/speedtest/config"
+)
+
+// startListeners serves h on all configured listeners and inherited systemd sockets until
+// ctx is cancelled or the first of them fails, then shuts all of them down gracefully
+func startListeners(ctx context.Context, conf *config.Config, h http.Handler) error {
+	// See if systemd socket activation has been used when starting our process
+	inherited, err := inheritedListeners()
+	if err != nil {
+		log.Fatalf("Error whilst checking for systemd socket activation %s", err)
+	}
+
+	listeners := conf.Listeners
+	if len(listeners) == 0 {
+		listeners = legacyListeners(conf, inherited)
+	}
+
+	var tlsConfig *tls.Config
+	for _, lc := range listeners {
+		if lc.TLS {
+			if tlsConfig, err = loadTLSConfig(conf); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	// plain HTTP listeners answer ACME HTTP-01 challenges
+	plain := h
+	if acmeManager != nil {
+		plain = acmeManager.HTTPHandler(h)
+	}
+
+	running := newServers()
+	serve := func(lc config.Listener, l net.Listener) error {
+		srv := newServer(lc, h)
+		if !lc.TLS {
+			srv.Handler = plain
+		}
+		if lc.HTTP3 {
+			h3, err := startHTTP3(lc, h, tlsConfig, running)
+			if err != nil {
+				return err
+			}
+			if h3 != nil {
+				srv.Handler = advertiseHTTP3(h3, srv.Handler)
+			}
+		}
+		log.Infof("Starting backend server on %s%s", l.Addr(), describeListener(lc))
+		running.start(srv, func() error {
+			return serveListener(srv, lc, l, tlsConfig)
+		})
+		return nil
+	}
+	listen := func() error {
+		for _, lc := range listeners {
+			if lc.SystemdName != "" {
+				sockets, ok := inherited[lc.SystemdName]
+				if !ok {
+					return fmt.Errorf("no socket named %s inherited via systemd socket activation", lc.SystemdName)
+				}
+				delete(inherited, lc.SystemdName)
+				for _, l := range sockets {
+					if err := serve(lc, l); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			network := lc.Network
+			if network == "" {
+				network = "tcp"
+			}
+			l, err := net.Listen(network, lc.Address)
+			if err != nil {
+				return fmt.Errorf("cannot listen on %s: %w", lc.Address, err)
+			}
+			if err := serve(lc, l); err != nil {
+				return err
+			}
+		}
+
+		// sockets without a [[listeners]] entry serve plain HTTP
+		for name, sockets := range inherited {
+			log.Infof("Serving socket %s inherited via systemd socket activation", name)
+			for _, l := range sockets {
+				if err := serve(config.Listener{SystemdName: name}, l); err != nil {
+					return err
+				}
+			}
+		}
+
+		if conf.EnableMetrics && conf.MetricsListen != "" {
+			if err := startMetricsServer(conf.MetricsListen, running); err != nil {
+				return err
+			}
+		}
+		if acmeManager != nil && conf.ACMEHTTPAddress != "" {
+			return startACMEChallengeServer(conf.ACMEHTTPAddress, running)
+		}
+		return nil
+	}
+	if err := listen(); err != nil {
+		running.close()
+		return err
+	}
+
+	if acmeManager != nil {
+		prefetchCertificates(conf)
+	}
+
+	select {
+	case err = <-running.errs:
+		log.Errorf("Server failed, shutting down: %s", err)
+	case <-ctx.Done():
+	}
+	running.shutdown(conf.ShutdownTimeout)
+	return err
+}
+
+// websockets tracks the WebSocket connections of latency tests, which http.Server.Shutdown
+// doesn't wait for since they are hijacked
+var websockets = newConnSet()
+
+type connSet struct {
+	lock    sync.Mutex
+	conns   map[*websocket.Conn]struct{}
+	closing bool
+	wg      sync.WaitGroup
+}
+
+func newConnSet() *connSet {
+	return &connSet{conns: make(map[*websocket.Conn]struct{})}
+}
+
+// add tracks conn, it returns false once shutdown has started
+func (s *connSet) add(conn *websocket.Conn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closing {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *connSet) remove(conn *websocket.Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.conns[conn]; ok {
+		delete(s.conns, conn)
+		s.wg.Done()
+	}
+}
+
+// shutdown refuses new connections and waits for the tracked ones to be removed. Those
+// still open when ctx ends are closed.
+func (s *connSet) shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	s.closing = true
+	s.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.lock.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.lock.Unlock()
+	return ctx.Err()
+}
+
+// shutdowner is implemented by http.Server and http3.Server
+type shutdowner interface {
+	Shutdown(context.Context) error
+	Close() error
+}
+
+// servers tracks the running servers, so that they can be shut down together
+type servers struct {
+	running []shutdowner
+	// errs holds the first failure of a server
+	errs chan error
+}
+
+func newServers() *servers {
+	return &servers{errs: make(chan error, 1)}
+}
+
+// start runs serve in the background and reports its error unless srv has been shut down
+func (s *servers) start(srv shutdowner, serve func() error) {
+	s.running = append(s.running, srv)
+	go func() {
+		if err := serve(); !errors.Is(err, http.ErrServerClosed) {
+			select {
+			case s.errs <- err:
+			default:
+			}
+		}
+	}()
+}
+
+// shutdown stops accepting connections and waits for running requests, such as download and
+// upload streams and latency WebSockets, to finish. Connections still open after timeout are closed.
+func (s *servers) shutdown(timeout time.Duration) {
+	log.Infof("Shutting down, waiting up to %s for running tests to finish", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, srv := range s.running {
+		wg.Add(1)
+		go func(srv shutdowner) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				_ = srv.Close()
+			}
+		}(srv)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = websockets.shutdown(ctx)
+	}()
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		log.Warnf("Running tests did not finish within %s, closed their connections", timeout)
+	} else {
+		log.Info("All running tests finished")
+	}
+}
+
+// close stops all servers immediately
+func (s *servers) close() {
+	for _, srv := range s.running {
+		_ = srv.Close()
+	}
+}
+
+// legacyListeners derives the listeners from bind_address, listen_port and
+// proxyprotocol_port, for configurations without [[listeners]]
+func legacyListeners(conf *config.Config, inherited map[string][]net.Listener) []config.Listener {
+	var listeners []config.Listener
+
+	if len(inherited) > 0 {
+		if conf.BindAddress != "" || conf.Port != "" {
+			log.Errorf("Both an address/port (%s:%s) has been specificed in the config AND externally configured socket activation has been detected", conf.BindAddress, conf.Port)
+			log.Fatal(`Please deconfigure socket activation (e.g. in systemd unit files), or set both 'bind_address' and 'listen_port' to '', or use [[listeners]]`)
+		}
+	} else {
+		listeners = append(listeners, config.Listener{
+			Address: net.JoinHostPort(conf.BindAddress, conf.Port),
+			TLS:     conf.EnableTLS,
+			HTTP2:   conf.EnableHTTP2,
+			HTTP3:   conf.EnableHTTP3,
+		})
+	}
+
+	if conf.ProxyProtocolPort != "0" {
+		listeners = append(listeners, config.Listener{
+			Address:       net.JoinHostPort(conf.BindAddress, conf.ProxyProtocolPort),
+			ProxyProtocol: true,
+		})
+	}
+
+	return listeners
+}
+
+func newServer(lc config.Listener, h http.Handler) *http.Server {
+	srv := &http.Server{Handler: h}
+
+	if lc.TLS && !lc.HTTP2 {
+		// a non-nil, empty map disables HTTP/2
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	if !lc.TLS && lc.HTTP2 {
+		log.Errorf("TLS is mandatory for HTTP/2. Ignore settings that enable HTTP/2.")
+	}
+
+	return srv
+}
+
+func serveListener(srv *http.Server, lc config.Listener, l net.Listener, tlsConfig *tls.Config) error {
+	if lc.ProxyProtocol {
+		l = &proxyproto.Listener{Listener: l}
+	}
+
+	if lc.TLS {
+		srv.TLSConfig = tlsConfig
+		return srv.ServeTLS(l, "", "")
+	}
+	return srv.Serve(l)
+}
+
+// startHTTP3 serves h over QUIC on the UDP port with the same address as lc. It returns nil
+// if HTTP/3 can't be used with lc.
+func startHTTP3(lc config.Listener, h http.Handler, tlsConfig *tls.Config, running *servers) (*http3.Server, error) {
+	if !lc.TLS {
+		log.Errorf("TLS is mandatory for HTTP/3. Ignore settings that enable HTTP/3 on %s.", lc.Address)
+		return nil, nil
+	}
+	if lc.SystemdName != "" {
+		log.Errorf("HTTP/3 is not supported on sockets inherited via systemd socket activation, ignoring it for %s", lc.SystemdName)
+		return nil, nil
+	}
+
+	network := "udp"
+	switch lc.Network {
+	case "tcp4":
+		network = "udp4"
+	case "tcp6":
+		network = "udp6"
+	}
+	conn, err := net.ListenPacket(network, lc.Address)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on %s/udp: %w", lc.Address, err)
+	}
+
+	srv := &http3.Server{
+		Handler:   h,
+		TLSConfig: tlsConfig,
+		Port:      conn.LocalAddr().(*net.UDPAddr).Port,
+	}
+	log.Infof("Starting HTTP/3 backend server on %s/udp", conn.LocalAddr())
+	running.start(srv, func() error {
+		return srv.Serve(conn)
+	})
+	return srv, nil
+}
+
+// advertiseHTTP3 adds the Alt-Svc header that lets clients switch to the HTTP/3 server
+func advertiseHTTP3(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = h3.SetQUICHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+}
+
+func loadTLSConfig(conf *config.Config) (*tls.Config, error) {
+	if conf.ACME {
+		if err := setupACME(conf); err != nil {
+			return nil, err
+		}
+		return acmeTLSConfig(), nil
+	}
+
+	reloader, err := newCertificateReloader(conf.TLSCertFile, conf.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{GetCertificate: reloader.GetCertificate}, nil
+}
+
+func describeListener(lc config.Listener) string {
+	var features []string
+	if lc.SystemdName != "" {
+		features = append(features, "systemd socket "+lc.SystemdName)
+	}
+	if lc.TLS {
+		features = append(features, "TLS")
+		if lc.HTTP2 {
+			features = append(features, "HTTP/2")
+		}
+		if lc.HTTP3 {
+			features = append(features, "HTTP/3")
+		}
+	}
+	if lc.ProxyProtocol {
+		features = append(features, "proxy protocol")
+	}
+
+	if len(features) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(features, ", ") + ")"
+}
@@ -0,0 +1,72 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConnSetShutdown(t *testing.T) {
+	set := newConnSet()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		if !set.add(conn) {
+			_ = conn.Close()
+			return
+		}
+		defer set.remove(conn)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// wait for the handler to track the connection
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		set.lock.Lock()
+		n := len(set.conns)
+		set.lock.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection was not tracked")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := set.shutdown(ctx); err == nil {
+		t.Error("shutdown returned before the open connection was closed")
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := client.ReadMessage(); err == nil {
+		t.Error("connection is still open after shutdown")
+	}
+	if set.add(&websocket.Conn{}) {
+		t.Error("connection added after shutdown")
+	}
+}
+
+func TestConnSetShutdownIdle(t *testing.T) {
+	if err := newConnSet().shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown without connections: %s", err)
+	}
+}
@@ -0,0 +1,179 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librespeed/speedtest/config"
+)
+
+const (
+	// peers that don't answer their health check within this time are unreachable
+	serverHealthTimeout = 5 * time.Second
+
+	serverOK          = "ok"
+	serverUnavailable = "unavailable"
+	serverUnreachable = "unreachable"
+)
+
+// serverEntry is the server definition format of Speedtest.loadServerList in speedtest.js
+type serverEntry struct {
+	ID               int    `json:"id,omitempty"`
+	Name             string `json:"name"`
+	Server           string `json:"server"`
+	Location         string `json:"location,omitempty"`
+	SponsorName      string `json:"sponsorName,omitempty"`
+	SponsorURL       string `json:"sponsorURL,omitempty"`
+	DownloadURL      string `json:"dlURL"`
+	UploadURL        string `json:"ulURL"`
+	PingURL          string `json:"pingURL"`
+	GetIPURL         string `json:"getIpURL"`
+	SessionURL       string `json:"sessionURL,omitempty"`
+	LatencyURL       string `json:"latencyURL,omitempty"`
+	LoadedLatencyURL string `json:"loadedLatencyURL,omitempty"`
+	// Status is the result of the last health check, if enabled
+	Status string `json:"status,omitempty"`
+}
+
+// serverList serves the [[servers]] of the configuration and keeps track of their health
+type serverList struct {
+	entries    []serverEntry
+	healthURLs []string
+
+	lock   sync.RWMutex
+	status []string
+}
+
+func newServerList(servers []config.Server) *serverList {
+	l := &serverList{status: make([]string, len(servers))}
+	for _, s := range servers {
+		entry := serverEntry{
+			ID:               s.ID,
+			Name:             s.Name,
+			Server:           s.Server,
+			Location:         s.Location,
+			SponsorName:      s.SponsorName,
+			SponsorURL:       s.SponsorURL,
+			DownloadURL:      defaultString(s.DownloadURL, "backend/garbage"),
+			UploadURL:        defaultString(s.UploadURL, "backend/empty"),
+			PingURL:          defaultString(s.PingURL, "backend/empty"),
+			GetIPURL:         defaultString(s.GetIPURL, "backend/getIP"),
+			SessionURL:       s.SessionURL,
+			LatencyURL:       s.LatencyURL,
+			LoadedLatencyURL: s.LoadedLatencyURL,
+		}
+		// servers with custom test paths are likely other implementations without sessions
+		if s.DownloadURL == "" {
+			entry.SessionURL = defaultString(s.SessionURL, "backend/session")
+			entry.LatencyURL = defaultString(s.LatencyURL, "backend/latency")
+			entry.LoadedLatencyURL = defaultString(s.LoadedLatencyURL, "backend/loaded_latency")
+		}
+		l.entries = append(l.entries, entry)
+		l.healthURLs = append(l.healthURLs, healthURL(s))
+	}
+	return l
+}
+
+func (l *serverList) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.lock.RLock()
+	entries := make([]serverEntry, len(l.entries))
+	for i, entry := range l.entries {
+		entry.Status = l.status[i]
+		entries[i] = entry
+	}
+	l.lock.RUnlock()
+
+	render.JSON(w, r, entries)
+}
+
+// watch checks the health of all servers every interval until ctx is cancelled
+func (l *serverList) watch(ctx context.Context, interval time.Duration) {
+	client := &http.Client{Timeout: serverHealthTimeout}
+	for {
+		var wg sync.WaitGroup
+		for i := range l.entries {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				status, err := checkServer(ctx, client, l.healthURLs[i])
+				if ctx.Err() != nil {
+					return
+				}
+				l.setStatus(i, status, err)
+			}(i)
+		}
+		wg.Wait()
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (l *serverList) setStatus(i int, status string, err error) {
+	l.lock.Lock()
+	previous := l.status[i]
+	l.status[i] = status
+	l.lock.Unlock()
+
+	if status == previous {
+		return
+	}
+	if status == serverOK {
+		log.Infof("Server %s is available", l.entries[i].Name)
+	} else {
+		log.Warnf("Server %s is %s: %s", l.entries[i].Name, status, err)
+	}
+}
+
+// checkServer queries the readiness endpoint of a server
+func checkServer(ctx context.Context, client *http.Client, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return serverUnreachable, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return serverUnreachable, err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return serverUnavailable, fmt.Errorf("health check returned %s", resp.Status)
+	}
+	return serverOK, nil
+}
+
+// healthURL resolves the health check URL of s against its server URL. Protocol relative
+// server URLs are checked over HTTPS.
+func healthURL(s config.Server) string {
+	path := defaultString(s.HealthURL, "readyz")
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+
+	base := s.Server
+	if strings.HasPrefix(base, "//") {
+		base = "https:" + base
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return base + strings.TrimPrefix(path, "/")
+}
+
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
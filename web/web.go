@@ -1,27 +1,30 @@
 package web
 
 import (
+	"context"
 	"embed"
-	"encoding/json"
+	"errors"
 	"io"
 	"io/fs"
-	"io/ioutil"
-	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"regexp"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/go-chi/render"
-	"github.com/pires/go-proxyproto"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/librespeed/speedtest/config"
+	"github.com/librespeed/speedtest/metrics"
 	"github.com/librespeed/speedtest/results"
+	"github.com/librespeed/speedtest/session"
+	"github.com/librespeed/speedtest/sites"
 )
 
 const (
@@ -37,9 +40,20 @@ var (
 	randomData = getRandomData(chunkSize)
 )
 
-func ListenAndServe(conf *config.Config) error {
+// ListenAndServe serves the speed test until ctx is cancelled, then waits up to
+// shutdown_timeout for running tests to finish
+func ListenAndServe(ctx context.Context, conf *config.Config) error {
+	return startListeners(ctx, conf, newRouter(ctx, conf))
+}
+
+// newRouter sets up the routes of the speed test, background tasks such as server health
+// checks run until ctx is cancelled
+func newRouter(ctx context.Context, conf *config.Config) http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.RealIP)
+	if conf.EnableMetrics {
+		r.Use(metrics.Middleware)
+	}
 	r.Use(middleware.GetHead)
 
 	cs := cors.New(cors.Options{
@@ -64,13 +78,37 @@ func ListenAndServe(conf *config.Config) error {
 		assetFS = justFilesFilesystem{fs: http.Dir(conf.AssetsPath), readDirBatchSize: 2}
 	}
 
+	limiter := newLimiter(ctx, conf)
+	limited := r.With(limitStreams(limiter))
+
 	r.Get(conf.BaseURL+"/*", pages(assetFS, conf.BaseURL))
-	r.HandleFunc(conf.BaseURL+"/empty", empty)
-	r.HandleFunc(conf.BaseURL+"/backend/empty", empty)
-	r.Get(conf.BaseURL+"/garbage", garbage)
-	r.Get(conf.BaseURL+"/backend/garbage", garbage)
+	if conf.EnableMetrics && conf.MetricsListen == "" {
+		log.Infof("Serving Prometheus metrics on %s/metrics", conf.BaseURL)
+		r.Get(conf.BaseURL+"/metrics", metricsHandler(conf))
+	}
+	limited.HandleFunc(conf.BaseURL+"/empty", empty)
+	limited.HandleFunc(conf.BaseURL+"/backend/empty", empty)
+	limited.Get(conf.BaseURL+"/garbage", garbage)
+	limited.Get(conf.BaseURL+"/backend/garbage", garbage)
+	if len(conf.Servers) > 0 {
+		servers := newServerList(conf.Servers)
+		if conf.ServersHealthCheckInterval > 0 {
+			go servers.watch(ctx, conf.ServersHealthCheckInterval)
+		}
+		r.Get(conf.BaseURL+"/servers.json", servers.ServeHTTP)
+		r.Get(conf.BaseURL+"/backend/servers.json", servers.ServeHTTP)
+	}
+	r.Get(conf.BaseURL+"/healthz", healthz)
+	r.Get(conf.BaseURL+"/readyz", newReadiness(ctx, assetFS).ServeHTTP)
+	r.Get(conf.BaseURL+"/version", versionHandler(conf, limiter != nil))
+	limited.Get(conf.BaseURL+"/latency", latency)
+	limited.Get(conf.BaseURL+"/backend/latency", latency)
+	limited.Get(conf.BaseURL+"/loaded_latency", loadedLatency)
+	limited.Get(conf.BaseURL+"/backend/loaded_latency", loadedLatency)
 	r.Get(conf.BaseURL+"/getIP", getIP)
 	r.Get(conf.BaseURL+"/backend/getIP", getIP)
+	limited.Get(conf.BaseURL+"/session", newSession)
+	limited.Get(conf.BaseURL+"/backend/session", newSession)
 	r.Get(conf.BaseURL+"/results", results.DrawPNG)
 	r.Get(conf.BaseURL+"/results/", results.DrawPNG)
 	r.Get(conf.BaseURL+"/backend/results", results.DrawPNG)
@@ -79,12 +117,15 @@ func ListenAndServe(conf *config.Config) error {
 	r.Post(conf.BaseURL+"/backend/results/telemetry", results.Record)
 	r.HandleFunc(conf.BaseURL+"/stats", results.Stats)
 	r.HandleFunc(conf.BaseURL+"/backend/stats", results.Stats)
+	r.Get(conf.BaseURL+"/stats/export", results.Export)
+	r.Get(conf.BaseURL+"/backend/stats/export", results.Export)
+	r.Get(conf.BaseURL+"/api/stats/summary", results.StatsSummary)
 
 	// PHP frontend default values compatibility
-	r.HandleFunc(conf.BaseURL+"/empty.php", empty)
-	r.HandleFunc(conf.BaseURL+"/backend/empty.php", empty)
-	r.Get(conf.BaseURL+"/garbage.php", garbage)
-	r.Get(conf.BaseURL+"/backend/garbage.php", garbage)
+	limited.HandleFunc(conf.BaseURL+"/empty.php", empty)
+	limited.HandleFunc(conf.BaseURL+"/backend/empty.php", empty)
+	limited.Get(conf.BaseURL+"/garbage.php", garbage)
+	limited.Get(conf.BaseURL+"/backend/garbage.php", garbage)
 	r.Get(conf.BaseURL+"/getIP.php", getIP)
 	r.Get(conf.BaseURL+"/backend/getIP.php", getIP)
 	r.Post(conf.BaseURL+"/results/telemetry.php", results.Record)
@@ -92,25 +133,7 @@ func ListenAndServe(conf *config.Config) error {
 	r.HandleFunc(conf.BaseURL+"/stats.php", results.Stats)
 	r.HandleFunc(conf.BaseURL+"/backend/stats.php", results.Stats)
 
-	go listenProxyProtocol(conf, r)
-
-	return startListener(conf, r)
-}
-
-func listenProxyProtocol(conf *config.Config, r *chi.Mux) {
-	if conf.ProxyProtocolPort != "0" {
-		addr := net.JoinHostPort(conf.BindAddress, conf.ProxyProtocolPort)
-		l, err := net.Listen("tcp", addr)
-		if err != nil {
-			log.Fatalf("Cannot listen on proxy protocol port %s: %s", conf.ProxyProtocolPort, err)
-		}
-
-		pl := &proxyproto.Listener{Listener: l}
-		defer pl.Close()
-
-		log.Infof("Starting proxy protocol listener on %s", addr)
-		log.Fatal(http.Serve(pl, r))
-	}
+	return r
 }
 
 func pages(fs http.FileSystem, BaseURL string) http.HandlerFunc {
@@ -132,19 +155,81 @@ func pages(fs http.FileSystem, BaseURL string) http.HandlerFunc {
 	return fn
 }
 
+// countingDiscard discards everything written to it, counting the bytes received
+type countingDiscard struct {
+	session  *session.Session
+	start    time.Time
+	received prometheus.Counter
+}
+
+func (c countingDiscard) Write(p []byte) (int, error) {
+	c.received.Add(float64(len(p)))
+	if c.session != nil {
+		c.session.Add(session.Upload, c.start, int64(len(p)))
+	}
+	return len(p), nil
+}
+
 func empty(w http.ResponseWriter, r *http.Request) {
-	_, err := io.Copy(ioutil.Discard, r.Body)
-	if err != nil {
+	metrics.ActiveStreams.WithLabelValues(metrics.StreamUpload).Inc()
+	defer metrics.ActiveStreams.WithLabelValues(metrics.StreamUpload).Dec()
+
+	sess, ok := testSession(w, r)
+	if !ok {
+		return
+	}
+
+	// ping requests have no body and don't load the link
+	if sess != nil && r.ContentLength != 0 {
+		sess.StreamStarted(session.Upload)
+		defer sess.StreamFinished(session.Upload)
+	}
+
+	n, err := io.Copy(countingDiscard{
+		session:  sess,
+		start:    time.Now(),
+		received: metrics.EmptyBytesReceived.WithLabelValues(r.Proto),
+	}, r.Body)
+	if isRateLimited(err) {
+		http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+		return
+	} else if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	_ = r.Body.Close()
 
-	w.Header().Set("Connection", "keep-alive")
+	// the ping test uses the same endpoint without request body
+	if sess != nil {
+		if n == 0 {
+			sess.AddPing()
+		} else {
+			sess.Logger().WithField("bytes", n).Debug("Upload stream finished")
+		}
+	}
+
+	// connection-specific headers are not allowed in HTTP/2 and HTTP/3
+	if r.ProtoMajor == 1 {
+		w.Header().Set("Connection", "keep-alive")
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
 func garbage(w http.ResponseWriter, r *http.Request) {
+	metrics.ActiveStreams.WithLabelValues(metrics.StreamDownload).Inc()
+	defer metrics.ActiveStreams.WithLabelValues(metrics.StreamDownload).Dec()
+
+	start := time.Now()
+	sess, ok := testSession(w, r)
+	if !ok {
+		return
+	}
+
+	if sess != nil {
+		sess.StreamStarted(session.Download)
+		defer sess.StreamFinished(session.Download)
+	}
+
 	w.Header().Set("Content-Description", "File Transfer")
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Disposition", "attachment; filename=random.dat")
@@ -169,52 +254,94 @@ func garbage(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	sentBytes := metrics.GarbageBytesSent.WithLabelValues(r.Proto)
+	var sent int64
 	for i := 0; i < chunks; i++ {
-		if _, err := w.Write(randomData); err != nil {
+		n, err := w.Write(randomData)
+		sent += int64(n)
+		sentBytes.Add(float64(n))
+		if sess != nil {
+			sess.Add(session.Download, start, int64(n))
+		}
+		if isRateLimited(err) {
+			break
+		} else if err != nil {
 			log.Errorf("Error writing back to client at chunk number %d: %s", i, err)
 			break
 		}
 	}
+
+	if sess != nil {
+		sess.Logger().WithField("bytes", sent).Debug("Download stream finished")
+	} else {
+		log.WithFields(log.Fields{"ip": clientIP(r), "bytes": sent}).Debug("Download stream without test session finished")
+	}
+}
+
+type sessionResponse struct {
+	Session string `json:"session"`
+}
+
+// newSession issues the ID that ties the requests of a test run together
+func newSession(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.New(clientIP(r))
+	if errors.Is(err, session.ErrTooManyClientSessions) {
+		log.WithField("ip", clientIP(r)).Warn("Refusing test session: too many active sessions for this client")
+		http.Error(w, "Too many test sessions, try again later", http.StatusTooManyRequests)
+		return
+	} else if err != nil {
+		log.Errorf("Cannot create test session: %s", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	sess.Logger().Debug("Test session started")
+	render.JSON(w, r, sessionResponse{Session: sess.ID})
+}
+
+// testSession looks up the session passed in the URL. The ID is only read from the URL
+// as parsing the form would consume the upload body. When require_session is enabled,
+// requests without a known session are refused and ok is false.
+func testSession(w http.ResponseWriter, r *http.Request) (sess *session.Session, ok bool) {
+	if id := r.URL.Query().Get("session"); id != "" {
+		sess = session.Get(id)
+	}
+
+	if sess == nil && config.LoadedConfig().RequireSession {
+		http.Error(w, "Unknown or missing test session", http.StatusForbidden)
+		return nil, false
+	}
+	return sess, true
 }
 
 func getIP(w http.ResponseWriter, r *http.Request) {
 	var ret results.Result
 
-	clientIP := r.RemoteAddr
-	clientIP = strings.ReplaceAll(clientIP, "::ffff:", "")
-
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err == nil {
-		clientIP = ip
-	}
-
-	isSpecialIP := true
-	switch {
-	case clientIP == "::1":
-		ret.ProcessedString = clientIP + " - localhost IPv6 access"
-	case strings.HasPrefix(clientIP, "fe80:"):
-		ret.ProcessedString = clientIP + " - link-local IPv6 access"
-	case strings.HasPrefix(clientIP, "127."):
-		ret.ProcessedString = clientIP + " - localhost IPv4 access"
-	case strings.HasPrefix(clientIP, "10."):
-		ret.ProcessedString = clientIP + " - private IPv4 access"
-	case regexp.MustCompile(`^172\.(1[6-9]|2\d|3[01])\.`).MatchString(clientIP):
-		ret.ProcessedString = clientIP + " - private IPv4 access"
-	case strings.HasPrefix(clientIP, "192.168"):
-		ret.ProcessedString = clientIP + " - private IPv4 access"
-	case strings.HasPrefix(clientIP, "169.254"):
-		ret.ProcessedString = clientIP + " - link-local IPv4 access"
-	case regexp.MustCompile(`^100\.([6-9][0-9]|1[0-2][0-7])\.`).MatchString(clientIP):
-		ret.ProcessedString = clientIP + " - CGNAT IPv4 access"
-	default:
-		isSpecialIP = false
-	}
-
-	if isSpecialIP {
-		b, _ := json.Marshal(&ret)
-		if _, err := w.Write(b); err != nil {
-			log.Errorf("Error writing to client: %s", err)
-		}
+	addr, err := netip.ParseAddr(clientIP(r))
+	if err != nil {
+		log.Errorf("Cannot parse client address %s: %s", r.RemoteAddr, err)
+		ret.ProcessedString = clientIP(r)
+		render.JSON(w, r, ret)
+		return
+	}
+	// IPv4 clients of dual stack listeners show up as IPv4-mapped IPv6 addresses
+	addr = addr.Unmap().WithZone("")
+	clientIP := addr.String()
+
+	var description string
+	ret.Address, description = classifyAddress(addr)
+
+	// internal networks are labeled with their site instead of ISP info
+	if site := sites.Lookup(clientIP); site != nil {
+		ret.ProcessedString = clientIP + " - " + site.Label()
+		ret.Site = site.Label()
+		render.JSON(w, r, ret)
+		return
+	}
+
+	if !ret.Address.Global {
+		ret.ProcessedString = clientIP + " - " + description
+		render.JSON(w, r, ret)
 		return
 	}
 
@@ -224,7 +351,13 @@ func getIP(w http.ResponseWriter, r *http.Request) {
 	ret.ProcessedString = clientIP
 
 	if getISPInfo {
-		ispInfo := getIPInfo(clientIP)
+		// tunneled clients are looked up by their IPv4 address
+		lookupIP := clientIP
+		if ret.Address.EmbeddedIPv4 != "" {
+			lookupIP = ret.Address.EmbeddedIPv4
+		}
+
+		ispInfo := getIPInfo(lookupIP)
 		ret.RawISPInfo = ispInfo
 
 		removeRegexp := regexp.MustCompile(`AS\d+\s`)